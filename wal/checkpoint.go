@@ -0,0 +1,103 @@
+package wal
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Checkpoint persists lsn as the checkpoint lsn in the master page, then
+// releases the log space before it for reusing.
+// lsn must be the lsn of the last byte of an entry, as returned by Write
+// or EntryReader.GetLastLSN, and must be already durable.
+// Must NOT be called inside mutex lock
+func (w *WAL) Checkpoint(lsn LSN) error {
+	isFirstErr, err := w.checkpoint(lsn)
+	if isFirstErr {
+		// outside the checkpoint lock => the fail callback can call Checkpoint or Shutdown
+		w.applyFailPolicy(err)
+	}
+	return err
+}
+
+// checkpoint returns true if writing the master page puts the WAL into the failed state
+func (w *WAL) checkpoint(lsn LSN) (bool, error) {
+	w.checkpointMut.Lock()
+	defer w.checkpointMut.Unlock()
+
+	w.mut.Lock()
+	durableLsn := w.durableLsn
+	checkpointLsn := w.checkpointLsn
+	latestEpoch := w.latestEpoch
+	writeErr := w.writeErr
+	isRecovering := w.isRecovering
+	_, isEntryEnd := slices.BinarySearch(w.entryEnds, lsn)
+	w.mut.Unlock()
+
+	if writeErr != nil {
+		return false, writeErr
+	}
+	if isRecovering {
+		return false, ErrRecovering
+	}
+	if lsn <= checkpointLsn {
+		return false, nil
+	}
+	if lsn > durableLsn {
+		return false, fmt.Errorf("checkpoint lsn %d is greater than durable lsn %d", lsn, durableLsn)
+	}
+	if !isEntryEnd {
+		// recovery would start decoding from the middle of an entry
+		return false, fmt.Errorf("checkpoint lsn %d is not the end of an entry", lsn)
+	}
+
+	start := time.Now()
+	if err := w.writeMasterPageToFile(w.newMasterPage(lsn, latestEpoch)); err != nil {
+		return w.fail(err), err
+	}
+	if fn := w.options.metrics.OnCheckpoint; fn != nil {
+		fn(lsn, time.Since(start))
+	}
+
+	w.releaseLogSpace(lsn)
+	return false, nil
+}
+
+// writeLatestEpoch persists the latest epoch with the current checkpoint lsn to the master page
+func (w *WAL) writeLatestEpoch() error {
+	w.checkpointMut.Lock()
+	defer w.checkpointMut.Unlock()
+
+	w.mut.Lock()
+	checkpointLsn := w.checkpointLsn
+	latestEpoch := w.latestEpoch
+	w.mut.Unlock()
+
+	return w.writeMasterPageToFile(w.newMasterPage(checkpointLsn, latestEpoch))
+}
+
+func (w *WAL) newMasterPage(checkpointLsn LSN, latestEpoch Epoch) *MasterPage {
+	return &MasterPage{
+		Version:       MasterPageFirstVersion,
+		LatestEpoch:   latestEpoch,
+		CheckpointLSN: checkpointLsn,
+		PageSizeLog:   w.layout.PageSizeLog(),
+		Checksum:      w.options.checksum,
+		NumPage:       w.diskNumPage,
+	}
+}
+
+// writeMasterPageToFile writes the master page to the slot that is not the latest one,
+// so the latest master page is kept if crashing while writing.
+// Needs to be called inside checkpointMut lock
+func (w *WAL) writeMasterPageToFile(masterPage *MasterPage) error {
+	masterPage.Sequence = w.masterSequence + 1
+	if err := WriteMasterPageSlot(w.file, masterPage); err != nil {
+		return newIOError("write", err)
+	}
+	if err := w.syncFile(); err != nil {
+		return newIOError("sync", err)
+	}
+	w.masterSequence = masterPage.Sequence
+	return nil
+}
@@ -0,0 +1,183 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/QuangTung97/go-wal/wal/filesys"
+)
+
+func (w *walTest) readMasterPage(t *testing.T) MasterPage {
+	data := w.readFile(t)
+
+	var masterPage MasterPage
+	err := ReadLatestMasterPage(bytes.NewReader(data), &masterPage)
+	require.Equal(t, nil, err)
+	return masterPage
+}
+
+func TestWAL__Checkpoint__Then_Recover(t *testing.T) {
+	w := newWalTest(t, 10, 4)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	w.addEntryAndNotify("input01")
+	lsn := w.addEntryAndNotify("input02")
+	lastLsn := w.addEntryAndNotify("input03")
+	assert.Equal(t, nil, w.wal.WaitDurable(lastLsn))
+
+	err := w.wal.Checkpoint(lsn)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, lsn, w.readMasterPage(t).CheckpointLSN)
+	assert.Equal(t, lsn, w.wal.checkpointLsn)
+
+	// smaller lsn is ignored
+	err = w.wal.Checkpoint(lsn - 1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, lsn, w.readMasterPage(t).CheckpointLSN)
+
+	w.reopen(t)
+	assert.Equal(t, []string{"input03"}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	w.addEntryAndNotify("input04")
+
+	w.reopen(t)
+	assert.Equal(t, []string{"input03", "input04"}, w.readAllRecoverEntries())
+}
+
+func TestWAL__Checkpoint__Not_Durable(t *testing.T) {
+	w := newWalTest(t, 10, 4)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	w.wal.Lock()
+	lsn, err := w.wal.Write(NewSimpleByteReader([]byte("input01")))
+	w.wal.Unlock()
+	assert.Equal(t, nil, err)
+
+	err = w.wal.Checkpoint(lsn)
+	assert.Equal(t, errors.New("checkpoint lsn 542 is greater than durable lsn 511"), err)
+	assert.Equal(t, LSN(testPageSize-1), w.readMasterPage(t).CheckpointLSN)
+}
+
+func TestWAL__Checkpoint__Not_End_Of_Entry(t *testing.T) {
+	w := newWalTest(t, 10, 4)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	entry := strings.Repeat("A", 100)
+	lsn1 := w.addEntryAndNotify("input01")
+	lsn2 := w.addEntryAndNotify(entry)
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn2))
+
+	err := w.wal.Checkpoint(lsn2 - 50)
+	assert.Equal(t, fmt.Errorf("checkpoint lsn %d is not the end of an entry", lsn2-50), err)
+	assert.Equal(t, LSN(testPageSize-1), w.readMasterPage(t).CheckpointLSN)
+
+	w.reopen(t)
+	assert.Equal(t, []string{"input01", entry}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	// the recovered entries are valid checkpoint lsn
+	assert.Equal(t, nil, w.wal.Checkpoint(lsn1))
+	assert.Equal(t, errors.New("checkpoint lsn 600 is not the end of an entry"), w.wal.Checkpoint(600))
+	assert.Equal(t, nil, w.wal.Checkpoint(lsn2))
+
+	w.reopen(t)
+	assert.Equal(t, []string(nil), w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+}
+
+func TestWAL__Checkpoint__Reuse_Disk_Pages(t *testing.T) {
+	w := newWalTest(t, 5, 8) // 3 pages in the ring
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	var entries []string
+	for i := 0; i < 10; i++ {
+		entry := strings.Repeat(string(rune('A'+i)), 400)
+		entries = append(entries, entry)
+
+		lsn := w.addEntryAndNotify(entry)
+		assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+		assert.Equal(t, nil, w.wal.Checkpoint(lsn))
+	}
+
+	w.addEntryAndNotify("last")
+
+	w.reopen(t)
+	assert.Equal(t, []string{"last"}, w.readAllRecoverEntries())
+}
+
+func TestWAL__Checkpoint__Write_Master_Page_Alternately(t *testing.T) {
+	w := newWalTest(t, 10, 4)
+	assert.Equal(t, uint64(0), w.readMasterPage(t).Sequence)
+
+	// the new epoch is written to the next slot
+	assert.Equal(t, nil, w.wal.FinishRecover())
+	assert.Equal(t, uint64(1), w.readMasterPage(t).Sequence)
+
+	lsn1 := w.addEntryAndNotify("input01")
+	lsn2 := w.addEntryAndNotify("input02")
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn2))
+
+	assert.Equal(t, nil, w.wal.Checkpoint(lsn1))
+	assert.Equal(t, uint64(2), w.readMasterPage(t).Sequence)
+
+	assert.Equal(t, nil, w.wal.Checkpoint(lsn2))
+	masterPage := w.readMasterPage(t)
+	assert.Equal(t, uint64(3), masterPage.Sequence)
+	assert.Equal(t, lsn2, masterPage.CheckpointLSN)
+
+	// both slots are valid
+	data := w.readFile(t)
+	for seq := uint64(2); seq <= 3; seq++ {
+		var slotPage MasterPage
+		offset := masterPageSlotOffset(seq)
+		err := ReadMasterPage(bytes.NewReader(data[offset:offset+masterPageSize]), &slotPage)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, seq, slotPage.Sequence)
+	}
+
+	// the sequence continues after reopening
+	w.reopen(t)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+	assert.Equal(t, uint64(4), w.readMasterPage(t).Sequence)
+
+	lsn3 := w.addEntryAndNotify("input03")
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn3))
+	assert.Equal(t, nil, w.wal.Checkpoint(lsn3))
+	assert.Equal(t, uint64(5), w.readMasterPage(t).Sequence)
+}
+
+func TestWAL__Checkpoint__Crash_While_Writing_Master_Page(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		w := newFaultWalTest(t, seed, 10, 4)
+		assert.Equal(t, nil, w.wal.FinishRecover())
+
+		lsn1 := w.addEntryAndNotify("input01")
+		lsn2 := w.addEntryAndNotify("input02")
+		assert.Equal(t, nil, w.wal.WaitDurable(lsn2))
+		assert.Equal(t, nil, w.wal.Checkpoint(lsn1))
+
+		// the master page is not synced
+		syncErr := errors.New("sync error")
+		w.fs.(*filesys.FaultFileSystem).InjectError(filesys.FaultOpSync, 0, syncErr)
+		assert.Equal(t, &IOError{Op: "sync", Err: syncErr}, w.wal.Checkpoint(lsn2))
+
+		w.crash(t, filesys.CrashTornWrites)
+
+		// either the old or the new master page
+		ckpt := w.wal.checkpointLsn
+		assert.Contains(t, []LSN{lsn1, lsn2}, ckpt)
+		if ckpt == lsn1 {
+			assert.Equal(t, []string{"input02"}, w.readAllRecoverEntries())
+		} else {
+			assert.Equal(t, []string(nil), w.readAllRecoverEntries())
+		}
+		assert.Equal(t, nil, w.wal.FinishRecover())
+	}
+}
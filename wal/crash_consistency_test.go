@@ -0,0 +1,307 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/QuangTung97/go-wal/wal/filesys"
+)
+
+// crashHarness runs random concurrent workloads on a WAL, crashes the file system
+// at random points, then recovers and checks the invariants of the TLA+ model
+// (https://github.com/QuangTung97/tla-plus/blob/master/WAL/WAL.tla):
+//   - every entry acknowledged as durable (by WaitDurable) is recovered
+//   - the recovered log is a prefix of the written log (no torn, reordered or missing entries)
+//   - the checkpoint lsn and the epochs are monotonic
+type crashHarness struct {
+	t      *testing.T
+	rand   *rand.Rand
+	w      *walTest
+	config crashHarnessConfig
+
+	// state of the current incarnation, protected by mut
+	mut     sync.Mutex
+	written map[LSN]string // last lsn => data, of the recovered and newly written entries
+	acked   []LSN          // lsn of the entries acknowledged by WaitDurable
+
+	checkpointLsn LSN
+	latestEpoch   Epoch
+}
+
+// crashHarnessConfig is the page size of the WAL and the sector size of the file system.
+// Writes are only torn at multiples of the sector size, so a page bigger than a sector can be torn
+type crashHarnessConfig struct {
+	pageSize   int64
+	sectorSize int64
+}
+
+func newCrashHarness(t *testing.T, seed int64, config crashHarnessConfig) *crashHarness {
+	fs := filesys.NewFaultFileSystem(filesys.NewMemFileSystem(), config.sectorSize, seed)
+	h := &crashHarness{
+		t:       t,
+		rand:    rand.New(rand.NewSource(seed)),
+		w:       newWalTestOnFS(t, fs, "/data/wal01", 16, 6, WithPageSize(config.pageSize)),
+		config:  config,
+		written: map[LSN]string{},
+	}
+	h.checkpointLsn = h.w.wal.checkpointLsn
+	h.recover()
+	return h
+}
+
+// recover reads the entries of the reopened WAL, checks them against the state
+// of the previous incarnation, then starts a new incarnation
+func (h *crashHarness) recover() {
+	t := h.t
+	wal := h.w.wal
+
+	ckpt := wal.checkpointLsn
+	require.GreaterOrEqual(t, ckpt, h.checkpointLsn, "checkpoint lsn must be monotonic")
+	if ckpt != h.checkpointLsn {
+		_, ok := h.written[ckpt]
+		require.True(t, ok, "checkpoint lsn %d must be the end of an entry", ckpt)
+	}
+
+	var expected []LSN
+	for lsn := range h.written {
+		if lsn > ckpt {
+			expected = append(expected, lsn)
+		}
+	}
+	sort.Slice(expected, func(i, j int) bool { return expected[i] < expected[j] })
+
+	recovered := map[LSN]string{}
+	lastLsn := ckpt
+	for wal.NextRecoverEntry() {
+		reader := wal.GetRecoveryEntry()
+		lsn := reader.GetLastLSN()
+
+		index := len(recovered)
+		require.Less(t, index, len(expected), "recovered entry at lsn %d was never written", lsn)
+		require.Equal(t, expected[index], lsn, "recovered entries must be a prefix of the written log")
+		require.Equal(t, h.written[lsn], readEntryReader(&reader), "entry at lsn %d", lsn)
+
+		recovered[lsn] = h.written[lsn]
+		lastLsn = lsn
+	}
+
+	for _, lsn := range h.acked {
+		require.LessOrEqual(t, lsn, lastLsn, "durable entry at lsn %d is lost", lsn)
+	}
+
+	lastPageEpoch := wal.recovery.prevEpoch
+	require.Equal(t, nil, wal.FinishRecover())
+
+	epoch := wal.latestEpoch
+	require.True(t, epoch.After(h.latestEpoch), "latest epoch must be increasing")
+	require.True(t, epoch.After(lastPageEpoch), "latest epoch must be newer than the log")
+	require.Equal(t, epoch, h.w.readMasterPage(t).LatestEpoch, "latest epoch must be durable")
+
+	h.written = recovered
+	h.acked = nil
+	h.checkpointLsn = ckpt
+	h.latestEpoch = epoch
+}
+
+func readEntryReader(reader *EntryReader) string {
+	var entry []byte
+	buf := make([]byte, 64)
+	for {
+		n, hasNext := reader.Read(buf)
+		entry = append(entry, buf[:n]...)
+		if !hasNext {
+			return string(entry)
+		}
+	}
+}
+
+// runAndCrash runs numWriters writers and a checkpointer concurrently,
+// crashes after about crashAfter entries are written, then reopens the WAL.
+// In some rounds an fsync fails before crashing, so the writes of the failed flush
+// are not synced when crashing, e.g. a torn rewrite of a page that contains durable entries
+func (h *crashHarness) runAndCrash(round int, numWriters int, numEntries int, crashAfter int) {
+	wal := h.w.wal
+	crashCh := make(chan struct{})
+	numWritten := 0
+
+	if h.rand.Intn(2) == 0 {
+		fs := h.w.fs.(*filesys.FaultFileSystem)
+		fs.InjectError(filesys.FaultOpSync, h.rand.Intn(numWriters*numEntries), errors.New("injected sync error"))
+	}
+
+	record := func(lsn LSN, data string) {
+		h.mut.Lock()
+		defer h.mut.Unlock()
+
+		h.written[lsn] = data
+		numWritten++
+		if numWritten == crashAfter {
+			close(crashCh)
+		}
+	}
+
+	var writerWg sync.WaitGroup
+	for id := 0; id < numWriters; id++ {
+		seed := h.rand.Int63()
+		writerWg.Add(1)
+		go func() {
+			defer writerWg.Done()
+			r := rand.New(rand.NewSource(seed))
+
+			for i := 0; i < numEntries; i++ {
+				data := newCrashTestEntry(r, h.config.pageSize, round, id, i)
+
+				var lsn LSN
+				if r.Intn(2) == 0 {
+					var err error
+					wal.Lock()
+					lsn, err = wal.Write(NewSimpleByteReader([]byte(data)))
+					if err == nil {
+						record(lsn, data)
+						wal.NotifyWriter()
+					}
+					wal.Unlock()
+					if err != nil {
+						return
+					}
+				} else {
+					e, err := wal.NewEntry(int64(len(data)))
+					if err != nil {
+						return
+					}
+					lsn = LSN(e.GetLastLSN())
+					record(lsn, data)
+
+					half := len(data) / 2
+					e.Write([]byte(data[:half]))
+					e.Write([]byte(data[half:]))
+					e.Finish()
+				}
+
+				if r.Intn(3) == 0 || i == numEntries-1 {
+					if err := wal.WaitDurable(lsn); err != nil {
+						return
+					}
+					h.mut.Lock()
+					h.acked = append(h.acked, lsn)
+					h.mut.Unlock()
+				}
+			}
+		}()
+	}
+
+	stopCh := make(chan struct{})
+	var checkpointWg sync.WaitGroup
+	checkpointWg.Add(1)
+	go func() {
+		defer checkpointWg.Done()
+		for {
+			select {
+			case <-stopCh:
+				return
+			default:
+			}
+
+			if lsn, ok := h.findCheckpointLsn(); ok {
+				if err := wal.Checkpoint(lsn); err != nil {
+					return
+				}
+			}
+			time.Sleep(100 * time.Microsecond)
+		}
+	}()
+
+	writersDone := make(chan struct{})
+	go func() {
+		writerWg.Wait()
+		close(writersDone)
+	}()
+
+	select {
+	case <-crashCh:
+	case <-writersDone:
+	}
+
+	// the entries acknowledged before crashing must be recovered
+	h.mut.Lock()
+	acked := append([]LSN{}, h.acked...)
+	h.mut.Unlock()
+
+	modes := []filesys.CrashMode{
+		filesys.CrashDropUnsynced,
+		filesys.CrashReorderUnsynced,
+		filesys.CrashTornWrites,
+	}
+	h.w.fs = h.w.fs.(*filesys.FaultFileSystem).Crash(modes[h.rand.Intn(len(modes))])
+
+	wal.Shutdown()
+	close(stopCh)
+	writerWg.Wait()
+	checkpointWg.Wait()
+
+	h.acked = acked
+	h.w.openWAL(h.t)
+	h.recover()
+}
+
+// findCheckpointLsn returns the lsn of the last durable entry
+func (h *crashHarness) findCheckpointLsn() (LSN, bool) {
+	wal := h.w.wal
+	wal.Lock()
+	durableLsn := wal.GetDurableLSN()
+	wal.Unlock()
+
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	var result LSN
+	for lsn := range h.written {
+		if lsn <= durableLsn && lsn > result {
+			result = lsn
+		}
+	}
+	return result, result > 0
+}
+
+// newCrashTestEntry returns a unique entry that can span many pages
+func newCrashTestEntry(r *rand.Rand, pageSize int64, round int, writer int, index int) string {
+	prefix := fmt.Sprintf("r%d-w%d-e%d:", round, writer, index)
+	size := r.Intn(int(3 * pageSize / 2))
+	return prefix + strings.Repeat(string(rune('a'+r.Intn(26))), size)
+}
+
+func TestWAL__Crash_Consistency__Random_Workloads(t *testing.T) {
+	numSeeds := int64(30)
+	if testing.Short() {
+		numSeeds = 5
+	}
+
+	configs := []crashHarnessConfig{
+		{pageSize: testPageSize, sectorSize: testPageSize},
+		{pageSize: 1024, sectorSize: 512},
+		{pageSize: 4096, sectorSize: 512},
+	}
+
+	for _, config := range configs {
+		for seed := int64(0); seed < numSeeds; seed++ {
+			name := fmt.Sprintf("page-%d-sector-%d-seed-%d", config.pageSize, config.sectorSize, seed)
+			t.Run(name, func(t *testing.T) {
+				h := newCrashHarness(t, seed, config)
+				for round := 0; round < 8; round++ {
+					numWriters := 1 + h.rand.Intn(4)
+					numEntries := 1 + h.rand.Intn(20)
+					crashAfter := 1 + h.rand.Intn(numWriters*numEntries)
+					h.runAndCrash(round, numWriters, numEntries, crashAfter)
+				}
+			})
+		}
+	}
+}
@@ -2,19 +2,36 @@ package wal
 
 import (
 	"encoding/binary"
+	"fmt"
+	"hash/crc32"
 )
 
 // --------------------------------------------------------------------
-// Format of a log entry
-// length: var-uint
+// Format of a log entry fragment
 // type: 1 byte
-// data: length of bytes - 1
+// length: var-uint (1 to 10 bytes)
+// data: length of bytes
+//
+// An entry that fits into the remaining bytes of a page is a single fragment
+// with EntryTypeNormal. Otherwise, it is split into fragments:
+// EntryTypeFirst fills the remaining bytes of the page, EntryTypeMiddle fills
+// the data part of the next pages and EntryTypeLast contains the rest of the data.
+// Every page of a split entry starts with a fragment header, so a reader
+// starting at any page knows whether it is in the middle of an entry.
+//
+// The data of an entry is followed by the entry checksum:
+// crc32 of the data: 4 bytes (little endian)
+// The checksum is a part of the entry when splitting into fragments,
+// so it is validated after the fragments are reassembled.
+//
+// A fragment header is only written if the remaining bytes of the page
+// are greater than maxLogEntryHeaderSize, otherwise they are filled with zeros.
 // --------------------------------------------------------------------
 
-// TODO remove
 const (
-	logEntryDataLengthOffset = 1
-	logEntryDataOffset       = logEntryDataLengthOffset + 2
+	logEntryLengthOffset  = 1
+	maxLogEntryHeaderSize = logEntryLengthOffset + binary.MaxVarintLen64
+	entryChecksumSize     = 4
 )
 
 // EntryType is type of log entry
@@ -29,20 +46,55 @@ const (
 	EntryTypeLast
 )
 
+// fragmentType returns the type of fragment based on its position in the entry
+func fragmentType(isFirst bool, isLast bool) EntryType {
+	switch {
+	case isFirst && isLast:
+		return EntryTypeNormal
+	case isFirst:
+		return EntryTypeFirst
+	case isLast:
+		return EntryTypeLast
+	default:
+		return EntryTypeMiddle
+	}
+}
+
+// WriteLogEntry writes the fragment header with length = dataLen,
+// then writes dataLen bytes of data from reader
 func WriteLogEntry(
 	pageData []byte, entryType EntryType,
 	reader ByteReader, dataLen int64,
 ) int64 {
+	headerSize := writeLogEntryHeader(pageData, entryType, dataLen)
+
+	pageData = pageData[headerSize:]
+	dataLen = WriteLogEntryDataOnly(pageData, reader, dataLen)
+	return headerSize + dataLen
+}
+
+// writeLogEntryHeader returns the size of the header
+func writeLogEntryHeader(pageData []byte, entryType EntryType, dataLen int64) int64 {
 	pageData[0] = byte(entryType)
+	n := binary.PutUvarint(pageData[logEntryLengthOffset:], uint64(dataLen))
+	return logEntryLengthOffset + int64(n)
+}
 
-	binary.LittleEndian.PutUint16(
-		pageData[logEntryDataLengthOffset:logEntryDataOffset],
-		uint16(dataLen),
-	)
+func logEntryHeaderSize(dataLen int64) int64 {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(dataLen))
+	return logEntryLengthOffset + int64(n)
+}
 
-	pageData = pageData[logEntryDataOffset:]
-	dataLen = WriteLogEntryDataOnly(pageData, reader, dataLen)
-	return logEntryDataOffset + dataLen
+// fragmentCapacity returns the max data length of a fragment
+// that is written to remainSize bytes of a page
+func fragmentCapacity(remainSize int64) int64 {
+	capacity := remainSize - logEntryHeaderSize(remainSize)
+	// the header of a smaller length can be shorter
+	for capacity+1+logEntryHeaderSize(capacity+1) <= remainSize {
+		capacity++
+	}
+	return capacity
 }
 
 func WriteLogEntryDataOnly(pageData []byte, reader ByteReader, dataLen int64) int64 {
@@ -56,16 +108,62 @@ func WriteLogEntryDataOnly(pageData []byte, reader ByteReader, dataLen int64) in
 	return dataLen
 }
 
-func ReadLogEntry(pageData []byte) (EntryType, []byte, int64) {
+var (
+	errEmptyLogEntry         = fmt.Errorf("%w: empty", ErrCorruptEntry)
+	errInvalidLogEntryType   = fmt.Errorf("%w: invalid type", ErrCorruptEntry)
+	errInvalidLogEntryLength = fmt.Errorf("%w: invalid length", ErrCorruptEntry)
+)
+
+// ReadLogEntry returns the fragment type, the fragment data and the number of consumed bytes.
+// Zero bytes are read as EntryTypeNone with one consumed byte.
+// Returns an error if the fragment header is malformed or the data exceeds pageData
+func ReadLogEntry(pageData []byte) (EntryType, []byte, int64, error) {
+	if len(pageData) == 0 {
+		return EntryTypeNone, nil, 0, errEmptyLogEntry
+	}
+
 	entryType := EntryType(pageData[0])
-	if entryType == EntryTypeNone {
-		return EntryTypeNone, nil, 1
+	switch entryType {
+	case EntryTypeNone:
+		return EntryTypeNone, nil, 1, nil
+	case EntryTypeNormal, EntryTypeFirst, EntryTypeMiddle, EntryTypeLast:
+	default:
+		return EntryTypeNone, nil, 0, fmt.Errorf("%w: %d", errInvalidLogEntryType, entryType)
+	}
+
+	length, n := binary.Uvarint(pageData[logEntryLengthOffset:])
+	if n <= 0 {
+		return EntryTypeNone, nil, 0, fmt.Errorf("%w: malformed var-uint", errInvalidLogEntryLength)
+	}
+
+	headerSize := logEntryLengthOffset + int64(n)
+	if length > uint64(int64(len(pageData))-headerSize) {
+		return EntryTypeNone, nil, 0, fmt.Errorf(
+			"%w: %d exceeds the remaining %d bytes",
+			errInvalidLogEntryLength, length, int64(len(pageData))-headerSize,
+		)
 	}
 
-	dataLen := binary.LittleEndian.Uint16(
-		pageData[logEntryDataLengthOffset:logEntryDataOffset],
-	)
+	dataLen := int64(length)
+	return entryType, pageData[headerSize : headerSize+dataLen], headerSize + dataLen, nil
+}
+
+// appendEntryChecksum appends the checksum of the entry data
+func appendEntryChecksum(buf []byte, crcSum uint32) []byte {
+	return binary.LittleEndian.AppendUint32(buf, crcSum)
+}
 
-	// TODO validate entry
-	return entryType, pageData[logEntryDataOffset : logEntryDataOffset+dataLen], logEntryDataOffset + int64(dataLen)
+// validateEntryChecksum checks the checksum at the end of the entry,
+// returns the entry data without the checksum
+func validateEntryChecksum(entry []byte, crcTable *crc32.Table) ([]byte, error) {
+	if len(entry) < entryChecksumSize {
+		return nil, &ChecksumError{Kind: DataKindEntry}
+	}
+
+	data := entry[:len(entry)-entryChecksumSize]
+	crcSum := binary.LittleEndian.Uint32(entry[len(data):])
+	if crc32.Checksum(data, crcTable) != crcSum {
+		return nil, &ChecksumError{Kind: DataKindEntry}
+	}
+	return data, nil
 }
@@ -1,11 +1,22 @@
 package wal
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// withChecksum returns the entry data followed by its checksum
+func withChecksum(data string) string {
+	return string(appendEntryChecksum([]byte(data), crc32.ChecksumIEEE([]byte(data))))
+}
+
 func TestEntryType(t *testing.T) {
 	assert.Equal(t, EntryType(0), EntryTypeNone)
 	assert.Equal(t, EntryType(1), EntryTypeNormal)
@@ -20,17 +31,19 @@ func TestLogEntry__Read_Write(t *testing.T) {
 
 	input := NewSimpleByteReader([]byte("test data 01"))
 	n := WriteLogEntry(page.data, EntryTypeNormal, input, input.Len())
-	assert.Equal(t, int64(15), n)
+	assert.Equal(t, int64(14), n)
 
-	entryType, data, n := ReadLogEntry(page.data)
-	assert.Equal(t, int64(15), n)
+	entryType, data, n, err := ReadLogEntry(page.data)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(14), n)
 	assert.Equal(t, EntryTypeNormal, entryType)
 	assert.Equal(t, "test data 01", string(data))
 	assert.Equal(t, 12, len(data))
 
 	// read null entry
 	page.data = page.data[n:]
-	entryType, data, n = ReadLogEntry(page.data)
+	entryType, data, n, err = ReadLogEntry(page.data)
+	assert.Equal(t, nil, err)
 	assert.Equal(t, int64(1), n)
 	assert.Equal(t, EntryTypeNone, entryType)
 	assert.Equal(t, "", string(data))
@@ -40,19 +53,175 @@ func TestLogEntry__Read_Write__Partial(t *testing.T) {
 	page := newTestPage()
 
 	input := NewSimpleByteReader([]byte("test data 01 with remain"))
-	n := WriteLogEntry(page.data, EntryTypeFull, input, 12)
-	assert.Equal(t, int64(15), n)
+	n := WriteLogEntry(page.data, EntryTypeFirst, input, 12)
+	assert.Equal(t, int64(14), n)
+	assert.Equal(t, int64(12), input.Len())
 
-	entryType, data, n := ReadLogEntry(page.data)
-	assert.Equal(t, int64(15), n)
-	assert.Equal(t, EntryTypeFull, entryType)
+	// the first fragment of a split entry
+	entryType, data, n, err := ReadLogEntry(page.data)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(14), n)
+	assert.Equal(t, EntryTypeFirst, entryType)
 	assert.Equal(t, "test data 01", string(data))
-	assert.Equal(t, 12, len(data))
+
+	// the last fragment
+	page.data = page.data[n:]
+	n = WriteLogEntry(page.data, EntryTypeLast, input, input.Len())
+	assert.Equal(t, int64(14), n)
+
+	entryType, data, n, err = ReadLogEntry(page.data)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(14), n)
+	assert.Equal(t, EntryTypeLast, entryType)
+	assert.Equal(t, " with remain", string(data))
 
 	// read null entry
 	page.data = page.data[n:]
-	entryType, data, n = ReadLogEntry(page.data)
+	entryType, data, n, err = ReadLogEntry(page.data)
+	assert.Equal(t, nil, err)
 	assert.Equal(t, int64(1), n)
 	assert.Equal(t, EntryTypeNone, entryType)
 	assert.Equal(t, "", string(data))
 }
+
+func TestLogEntryHeaderSize(t *testing.T) {
+	assert.Equal(t, int64(2), logEntryHeaderSize(0))
+	assert.Equal(t, int64(2), logEntryHeaderSize(127))
+	assert.Equal(t, int64(3), logEntryHeaderSize(128))
+	assert.Equal(t, int64(4), logEntryHeaderSize(65536))
+	assert.Equal(t, 11, maxLogEntryHeaderSize)
+}
+
+func TestLogEntry__Read_Write__Big_Entry_Length(t *testing.T) {
+	page := newTestPage()
+
+	input := NewSimpleByteReader(bytes.Repeat([]byte("A"), 100000))
+	n := WriteLogEntry(page.data, EntryTypeNormal, input, 300)
+	assert.Equal(t, int64(303), n)
+
+	entryType, data, n, err := ReadLogEntry(page.data[:303])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(303), n)
+	assert.Equal(t, EntryTypeNormal, entryType)
+	assert.Equal(t, strings.Repeat("A", 300), string(data))
+
+	// fragment length exceeds the page
+	entryType, data, n, err = ReadLogEntry(page.data[:302])
+	assert.Equal(t, true, errors.Is(err, errInvalidLogEntryLength))
+	assert.Equal(t, true, errors.Is(err, ErrCorruptEntry))
+	assert.Equal(t, "corrupt log entry: invalid length: 300 exceeds the remaining 299 bytes", err.Error())
+	assert.Equal(t, int64(0), n)
+	assert.Equal(t, EntryTypeNone, entryType)
+	assert.Equal(t, "", string(data))
+}
+
+func TestReadLogEntry__Malformed(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		_, _, _, err := ReadLogEntry(nil)
+		assert.Equal(t, errEmptyLogEntry, err)
+	})
+
+	t.Run("invalid type", func(t *testing.T) {
+		for _, entryType := range []EntryType{EntryTypeFull, EntryTypeLast + 1, 255} {
+			_, _, _, err := ReadLogEntry([]byte{byte(entryType), 1, 'A'})
+			assert.Equal(t, true, errors.Is(err, errInvalidLogEntryType))
+		}
+	})
+
+	t.Run("missing length", func(t *testing.T) {
+		_, _, _, err := ReadLogEntry([]byte{byte(EntryTypeNormal)})
+		assert.Equal(t, true, errors.Is(err, errInvalidLogEntryLength))
+	})
+
+	t.Run("unfinished var-uint", func(t *testing.T) {
+		_, _, _, err := ReadLogEntry([]byte{byte(EntryTypeNormal), 0x80, 0x80})
+		assert.Equal(t, true, errors.Is(err, errInvalidLogEntryLength))
+	})
+
+	t.Run("var-uint overflow", func(t *testing.T) {
+		data := append([]byte{byte(EntryTypeNormal)}, bytes.Repeat([]byte{0xff}, 10)...)
+		data = append(data, 0x01)
+		_, _, _, err := ReadLogEntry(data)
+		assert.Equal(t, true, errors.Is(err, errInvalidLogEntryLength))
+	})
+
+	t.Run("max uint64 length", func(t *testing.T) {
+		data := binary.AppendUvarint([]byte{byte(EntryTypeNormal)}, math.MaxUint64)
+		_, _, _, err := ReadLogEntry(append(data, 'A'))
+		assert.Equal(t, true, errors.Is(err, errInvalidLogEntryLength))
+	})
+}
+
+func FuzzReadLogEntry(f *testing.F) {
+	page := newTestPage()
+	input := NewSimpleByteReader([]byte("test data 01"))
+	n := WriteLogEntry(page.data, EntryTypeNormal, input, input.Len())
+
+	f.Add(page.data[:n])
+	f.Add(page.data[:n-1])
+	f.Add([]byte{})
+	f.Add([]byte{byte(EntryTypeFirst), 0x80, 0x01, 'A'})
+	f.Add([]byte{byte(EntryTypeLast), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01})
+
+	f.Fuzz(func(t *testing.T, pageData []byte) {
+		entryType, data, consumed, err := ReadLogEntry(pageData)
+		if err != nil {
+			return
+		}
+		if consumed <= 0 || consumed > int64(len(pageData)) {
+			t.Fatalf("invalid consumed bytes %d of %d bytes", consumed, len(pageData))
+		}
+		if int64(len(data)) >= consumed {
+			t.Fatalf("data length %d is not less than consumed bytes %d", len(data), consumed)
+		}
+
+		if entryType == EntryTypeNone {
+			return
+		}
+
+		// the fragment is read back after writing
+		buf := make([]byte, consumed)
+		written := WriteLogEntry(buf, entryType, NewSimpleByteReader(data), int64(len(data)))
+		newType, newData, newConsumed, err := ReadLogEntry(buf[:written])
+		if err != nil {
+			t.Fatalf("read written fragment: %v", err)
+		}
+		if newType != entryType || !bytes.Equal(newData, data) || newConsumed != written {
+			t.Fatalf("mismatch written fragment")
+		}
+	})
+}
+
+func TestFragmentType(t *testing.T) {
+	assert.Equal(t, EntryTypeNormal, fragmentType(true, true))
+	assert.Equal(t, EntryTypeFirst, fragmentType(true, false))
+	assert.Equal(t, EntryTypeMiddle, fragmentType(false, false))
+	assert.Equal(t, EntryTypeLast, fragmentType(false, true))
+}
+
+func TestFragmentCapacity(t *testing.T) {
+	assert.Equal(t, int64(10), fragmentCapacity(12))
+	assert.Equal(t, int64(125), fragmentCapacity(127))
+	assert.Equal(t, int64(126), fragmentCapacity(128))
+	assert.Equal(t, int64(491), fragmentCapacity(testDataSizePerPage))
+}
+
+func TestEntryChecksum(t *testing.T) {
+	entry := []byte(withChecksum("input01"))
+	assert.Equal(t, 7+entryChecksumSize, len(entry))
+
+	data, err := validateEntryChecksum(entry, crc32.IEEETable)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "input01", string(data))
+
+	// corrupted data
+	entry[2] = 'X'
+	data, err = validateEntryChecksum(entry, crc32.IEEETable)
+	assert.Equal(t, &ChecksumError{Kind: DataKindEntry}, err)
+	assert.Equal(t, true, errors.Is(err, ErrChecksumMismatch))
+	assert.Equal(t, []byte(nil), data)
+
+	// too short
+	_, err = validateEntryChecksum([]byte("abc"), crc32.IEEETable)
+	assert.Equal(t, &ChecksumError{Kind: DataKindEntry}, err)
+}
@@ -0,0 +1,268 @@
+package wal
+
+import (
+	"hash/crc32"
+
+	"github.com/QuangTung97/go-wal/wal/types"
+)
+
+var _ types.WalWriter = &WAL{}
+
+// NewEntry reserves the lsn range of an entry with data length = dataLen.
+// The data is copied to the log buffer by the returned writer without holding the mutex,
+// so many writers can copy their entries in parallel.
+// Errors happened after reserving (e.g. the WAL is closed while copying)
+// are returned by WaitDurable(GetLastLSN()).
+// Must NOT be called inside mutex lock
+func (w *WAL) NewEntry(dataLen int64) (types.LogEntryWriter, error) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+
+	if err := w.checkWritable(); err != nil {
+		return nil, err
+	}
+	if err := w.checkEntrySize(dataLen); err != nil {
+		return nil, err
+	}
+
+	return w.newLogEntryWriter(dataLen, false), nil
+}
+
+// logEntryWriter copies the entry data into the reserved range of the log buffer.
+// The copied bytes are linked (marked as copied) when moving to another page or finishing,
+// the background writer only writes the contiguous prefix of copied bytes
+type logEntryWriter struct {
+	wal      *WAL
+	lockHeld bool // the entry is written inside the WAL mutex lock
+
+	nextOffset LogDataOffset // offset of the next byte to copy
+	endOffset  LogDataOffset // offset of the last byte of the entry
+
+	remainLen  int64  // entry data and checksum that are not yet copied
+	fragRemain int64  // data of the current fragment that is not yet copied
+	crcSum     uint32 // checksum of the copied entry data
+
+	linkedOffset LogDataOffset // bytes in (linkedOffset, nextOffset) are copied but not yet linked
+	pageNum      PageNum       // the page that already acquired
+	err          error
+}
+
+var _ types.LogEntryWriter = &logEntryWriter{}
+
+// entryRange returns the offset of the first fragment header and the offset of the last byte
+// of the next entry with data length = dataLen (including the entry checksum).
+// Needs to be called inside mutex lock
+func (w *WAL) entryRange(dataLen int64) (LogDataOffset, LogDataOffset) {
+	dataLen += entryChecksumSize
+
+	l := w.layout
+	start := w.latestOffset + 1
+	if remain := w.remainPageSize(l.ToLSN(start)); remain <= maxLogEntryHeaderSize {
+		// not enough space for the fragment header => skip to the next page
+		start += LogDataOffset(remain)
+	}
+
+	firstCapacity := fragmentCapacity(w.remainPageSize(l.ToLSN(start)))
+	if dataLen <= firstCapacity {
+		return start, start + LogDataOffset(logEntryHeaderSize(dataLen)+dataLen) - 1
+	}
+
+	// the remaining data is written to the next pages, each page contains a fragment
+	remainLen := dataLen - firstCapacity
+	pageCapacity := fragmentCapacity(l.DataSizePerPage())
+	numPages := (remainLen + pageCapacity - 1) / pageCapacity
+	lastLen := remainLen - (numPages-1)*pageCapacity
+
+	lastPageNum := l.OffsetToPageNum(start) + PageNum(numPages)
+	lastPageStart := LogDataOffset(lastPageNum) * LogDataOffset(l.DataSizePerPage())
+	return start, lastPageStart + LogDataOffset(logEntryHeaderSize(lastLen)+lastLen) - 1
+}
+
+// remainPageSize returns the number of bytes from lsn to the end of its page
+func (w *WAL) remainPageSize(lsn LSN) int64 {
+	return w.layout.PageSize() - w.layout.WithinPage(lsn)
+}
+
+// newLogEntryWriter needs to be called inside mutex lock
+func (w *WAL) newLogEntryWriter(dataLen int64, lockHeld bool) *logEntryWriter {
+	start, end := w.entryRange(dataLen)
+
+	e := &logEntryWriter{
+		wal:      w,
+		lockHeld: lockHeld,
+
+		nextOffset: start,
+		endOffset:  end,
+		remainLen:  dataLen + entryChecksumSize,
+
+		linkedOffset: w.latestOffset,
+		pageNum:      w.layout.OffsetToPageNum(start),
+	}
+	w.latestOffset = end
+	w.entryEnds = append(w.entryEnds, w.layout.ToLSN(end))
+
+	e.err = w.acquireInMemPage(e.pageNum)
+	if e.err == nil {
+		e.writeFragmentHeader(true)
+	}
+	return e
+}
+
+// writeFragmentHeader writes the header of the fragment at nextOffset
+func (e *logEntryWriter) writeFragmentHeader(isFirst bool) {
+	l := e.wal.layout
+	lsn := l.ToLSN(e.nextOffset)
+	fragLen := min(e.remainLen, fragmentCapacity(e.wal.remainPageSize(lsn)))
+	entryType := fragmentType(isFirst, fragLen == e.remainLen)
+
+	page := e.wal.getInMemPage(l.ToPageNum(lsn))
+	headerSize := writeLogEntryHeader(page.data[l.WithinPage(lsn):], entryType, fragLen)
+
+	e.nextOffset += LogDataOffset(headerSize)
+	e.fragRemain = fragLen
+}
+
+func (e *logEntryWriter) GetLastLSN() types.LSN {
+	return types.LSN(e.wal.layout.ToLSN(e.endOffset))
+}
+
+func (e *logEntryWriter) Write(data []byte) {
+	if int64(len(data)) > e.remainLen-entryChecksumSize {
+		panic("writing data exceeds the entry length")
+	}
+	e.crcSum = crc32.Update(e.crcSum, e.wal.checksumTable, data)
+	e.copyData(data)
+}
+
+// copyData copies the data to the reserved range, moving to the next pages if needed
+func (e *logEntryWriter) copyData(data []byte) {
+	for len(data) > 0 && e.err == nil {
+		if e.fragRemain == 0 {
+			e.moveToNextPage()
+			continue
+		}
+
+		l := e.wal.layout
+		lsn := l.ToLSN(e.nextOffset)
+		n := min(int64(len(data)), e.fragRemain)
+		page := e.wal.getInMemPage(l.ToPageNum(lsn))
+		copy(page.data[l.WithinPage(lsn):], data[:n])
+
+		e.nextOffset += LogDataOffset(n)
+		e.fragRemain -= n
+		e.remainLen -= n
+		data = data[n:]
+	}
+}
+
+// moveToNextPage links the copied bytes, including the unused bytes at the end of the current page,
+// then acquires the next page and writes the header of the next fragment
+func (e *logEntryWriter) moveToNextPage() {
+	num := e.pageNum + 1
+	e.nextOffset = LogDataOffset(num) * LogDataOffset(e.wal.layout.DataSizePerPage())
+
+	e.lock()
+	e.link()
+	e.err = e.wal.acquireInMemPage(num)
+	e.unlock()
+
+	e.pageNum = num
+	if e.err == nil {
+		e.writeFragmentHeader(false)
+	}
+}
+
+// Finish writes the entry checksum, links the copied bytes and notifies the background writer
+func (e *logEntryWriter) Finish() {
+	e.writeChecksum()
+
+	e.lock()
+	defer e.unlock()
+
+	e.finish()
+	e.wal.NotifyWriter()
+}
+
+// writeChecksum copies the checksum of the entry data after the data
+func (e *logEntryWriter) writeChecksum() {
+	if e.err != nil {
+		return
+	}
+	if e.remainLen > entryChecksumSize {
+		panic("entry data is not fully written")
+	}
+
+	var buf [entryChecksumSize]byte
+	e.copyData(appendEntryChecksum(buf[:0], e.crcSum))
+}
+
+// finish needs to be called inside mutex lock
+func (e *logEntryWriter) finish() {
+	if e.err != nil {
+		return
+	}
+	e.link()
+}
+
+// link needs to be called inside mutex lock
+func (e *logEntryWriter) link() {
+	copied := e.nextOffset - 1
+	if copied <= e.linkedOffset {
+		return
+	}
+	e.wal.linkCopiedRange(e.linkedOffset, copied)
+	e.linkedOffset = copied
+}
+
+func (e *logEntryWriter) lock() {
+	if !e.lockHeld {
+		e.wal.mut.Lock()
+	}
+}
+
+func (e *logEntryWriter) unlock() {
+	if !e.lockHeld {
+		e.wal.mut.Unlock()
+	}
+}
+
+// linkCopiedRange marks the bytes in range (from, to] of the log buffer as copied,
+// then advances copiedOffset to the end of the contiguous copied prefix.
+// Needs to be called inside mutex lock
+func (w *WAL) linkCopiedRange(from LogDataOffset, to LogDataOffset) {
+	if from != w.copiedOffset {
+		w.copiedLinks[from] = to
+		return
+	}
+
+	w.copiedOffset = to
+	for {
+		next, ok := w.copiedLinks[w.copiedOffset]
+		if !ok {
+			return
+		}
+		delete(w.copiedLinks, w.copiedOffset)
+		w.copiedOffset = next
+	}
+}
+
+// acquireInMemPage waits until the page can be stored in the log buffer
+// then initializes it if not yet.
+// Needs to be called inside mutex lock
+func (w *WAL) acquireInMemPage(num PageNum) error {
+	for !w.isInMemPageFree(num) {
+		if err := w.checkWritable(); err != nil {
+			return err
+		}
+
+		// the copied bytes need to be flushed to free the log buffer
+		w.NotifyWriter()
+		w.cond.Wait()
+	}
+
+	page := w.getInMemPage(num)
+	if page.GetVersion() == 0 || page.GetPageNum() != num {
+		InitPage(&page, w.latestEpoch, num)
+	}
+	return nil
+}
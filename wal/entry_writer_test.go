@@ -0,0 +1,158 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/QuangTung97/go-wal/wal/types"
+)
+
+func (w *walTest) newEntryWithLen(dataLen int64) types.LogEntryWriter {
+	e, err := w.wal.NewEntry(dataLen)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+func (w *walTest) newEntry(input string) types.LogEntryWriter {
+	e := w.newEntryWithLen(int64(len(input)))
+	e.Write([]byte(input))
+	return e
+}
+
+func TestWAL__Link_Copied_Range(t *testing.T) {
+	w := newWalTest(t, 10, 4)
+
+	start := w.wal.copiedOffset
+
+	w.wal.linkCopiedRange(start+10, start+20)
+	w.wal.linkCopiedRange(start+20, start+25)
+	assert.Equal(t, start, w.wal.copiedOffset)
+
+	w.wal.linkCopiedRange(start, start+5)
+	assert.Equal(t, start+5, w.wal.copiedOffset)
+
+	w.wal.linkCopiedRange(start+5, start+10)
+	assert.Equal(t, start+25, w.wal.copiedOffset)
+	assert.Equal(t, 0, len(w.wal.copiedLinks))
+}
+
+func TestWAL__New_Entry__Only_Contiguous_Prefix_Is_Written(t *testing.T) {
+	w := newWalTest(t, 10, 4)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	e1 := w.newEntryWithLen(7)
+	e2 := w.newEntryWithLen(7)
+	assert.Equal(t, types.LSN(testPageSize+pageHeaderSize+13-1), e1.GetLastLSN())
+	assert.Equal(t, types.LSN(testPageSize+pageHeaderSize+26-1), e2.GetLastLSN())
+
+	e2.Write([]byte("input02"))
+	e2.Finish()
+
+	w.wal.Lock()
+	assert.Equal(t, LSN(testPageSize-1), w.wal.writtenLsn)
+	w.wal.Unlock()
+
+	e1.Write([]byte("input"))
+	e1.Write([]byte("01"))
+	e1.Finish()
+
+	assert.Equal(t, nil, w.wal.WaitDurable(LSN(e2.GetLastLSN())))
+
+	w.reopen(t)
+	assert.Equal(t, []string{"input01", "input02"}, w.readAllRecoverEntries())
+}
+
+func TestWAL__New_Entry__Concurrent_Writers(t *testing.T) {
+	w := newWalTest(t, 100, 4)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	const numWriters = 10
+	const numEntries = 20
+
+	var wg sync.WaitGroup
+	for i := 0; i < numWriters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for k := 0; k < numEntries; k++ {
+				input := fmt.Sprintf("writer %02d entry %02d ", i, k)
+				input = input + strings.Repeat("X", (i*numEntries+k)%300)
+
+				e := w.newEntry(input)
+				e.Finish()
+
+				err := w.wal.WaitDurable(LSN(e.GetLastLSN()))
+				assert.Equal(t, nil, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	w.reopen(t)
+	entries := w.readAllRecoverEntries()
+	assert.Equal(t, numWriters*numEntries, len(entries))
+
+	// entries of the same writer are in order
+	nextEntry := map[string]int{}
+	for _, entry := range entries {
+		var writer, index int
+		_, err := fmt.Sscanf(entry, "writer %02d entry %02d ", &writer, &index)
+		assert.Equal(t, nil, err)
+
+		key := fmt.Sprint(writer)
+		assert.Equal(t, nextEntry[key], index)
+		nextEntry[key]++
+	}
+}
+
+func TestWAL__New_Entry__Bigger_Than_Log_Buffer(t *testing.T) {
+	w := newWalTest(t, 20, 2)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	input := strings.Repeat("A", 1000) + strings.Repeat("B", 1500)
+
+	e := w.newEntryWithLen(int64(len(input)))
+	for i := 0; i < len(input); i += 100 {
+		e.Write([]byte(input[i : i+100]))
+	}
+	e.Finish()
+
+	assert.Equal(t, nil, w.wal.WaitDurable(LSN(e.GetLastLSN())))
+
+	w.reopen(t)
+	assert.Equal(t, []string{input}, w.readAllRecoverEntries())
+}
+
+func TestWAL__New_Entry__After_Shutdown(t *testing.T) {
+	w := newWalTest(t, 20, 2)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+	w.wal.Shutdown()
+
+	e, err := w.wal.NewEntry(7)
+	assert.Equal(t, nil, e)
+	assert.Equal(t, ErrClosed, err)
+}
+
+func TestWAL__New_Entry__Exceed_Max_Entry_Size(t *testing.T) {
+	w := newWalTest(t, 20, 2, WithMaxEntrySize(100))
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	e, err := w.wal.NewEntry(101)
+	assert.Equal(t, nil, e)
+	assert.Equal(t, true, errors.Is(err, ErrEntryTooLarge))
+	assert.Equal(t, "entry too large: entry size 101 exceeds the max entry size 100", err.Error())
+
+	e, err = w.wal.NewEntry(100)
+	assert.Equal(t, nil, err)
+	e.Write([]byte(strings.Repeat("A", 100)))
+	e.Finish()
+	assert.Equal(t, nil, w.wal.WaitDurable(LSN(e.GetLastLSN())))
+}
@@ -0,0 +1,128 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/QuangTung97/go-wal/wal/filesys"
+)
+
+var (
+	// ErrLogBufferFull is returned by TryWrite when the log buffer does not have
+	// enough free pages for the entry. The write can be retried later
+	ErrLogBufferFull = errors.New("log buffer is full")
+
+	// ErrClosed is returned when writing to or waiting on a WAL that is shut down
+	ErrClosed = errors.New("wal is closed")
+
+	// ErrRecovering is returned when writing to, waiting on or checkpointing a WAL
+	// before FinishRecover has succeeded
+	ErrRecovering = errors.New("wal is recovering")
+
+	// ErrFileLocked is returned by Open when the WAL file is already opened,
+	// by another process or by another WAL that is not yet shut down
+	ErrFileLocked = filesys.ErrLocked
+
+	// ErrChecksumMismatch matches every ChecksumError with errors.Is
+	ErrChecksumMismatch = errors.New("mismatch checksum")
+
+	// ErrUnsupportedVersion matches every VersionError with errors.Is
+	ErrUnsupportedVersion = errors.New("unsupported version")
+
+	// ErrEntryTooLarge is returned when writing an entry bigger than the max entry size
+	ErrEntryTooLarge = errors.New("entry too large")
+
+	// ErrCorruptEntry is wrapped by the errors of a malformed log entry in a page
+	ErrCorruptEntry = errors.New("corrupt log entry")
+
+	// ErrCorruptPage is wrapped by the errors when a page of the log that was valid
+	// during the recovery can not be read again
+	ErrCorruptPage = errors.New("corrupt page")
+
+	// ErrInvalidPageSize is wrapped by the errors of a page size that is not supported
+	ErrInvalidPageSize = errors.New("invalid page size")
+
+	// ErrIncompatibleFile is wrapped by the errors of Open when the existing WAL file
+	// has a different page size or checksum algorithm than the options
+	ErrIncompatibleFile = errors.New("incompatible wal file")
+)
+
+// DataKind is the kind of the on-disk data that an error is about
+type DataKind int
+
+const (
+	DataKindPage DataKind = iota + 1
+	DataKindEntry
+	DataKindMasterPage
+)
+
+func (k DataKind) String() string {
+	switch k {
+	case DataKindPage:
+		return "page"
+	case DataKindEntry:
+		return "entry"
+	case DataKindMasterPage:
+		return "master page"
+	default:
+		return fmt.Sprintf("data kind %d", int(k))
+	}
+}
+
+// ChecksumError is returned when the checksum of a page, an entry or the master page does not match
+type ChecksumError struct {
+	Kind    DataKind
+	PageNum PageNum // the page containing the data, zero if unknown
+	LSN     LSN     // lsn of the last byte of the entry, zero if not an entry
+}
+
+func (e *ChecksumError) Error() string {
+	msg := fmt.Sprintf("mismatch %s checksum", e.Kind)
+	if e.LSN != 0 {
+		msg += fmt.Sprintf(": entry at lsn %d", e.LSN)
+	}
+	if e.PageNum != 0 {
+		msg += fmt.Sprintf(": page %d", e.PageNum)
+	}
+	return msg
+}
+
+func (e *ChecksumError) Is(target error) bool {
+	return target == ErrChecksumMismatch
+}
+
+// VersionError is returned when a page or the master page has a version that is not supported
+type VersionError struct {
+	Kind    DataKind
+	Version uint8
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("unsupported %s version: %d", e.Kind, e.Version)
+}
+
+func (e *VersionError) Is(target error) bool {
+	return target == ErrUnsupportedVersion
+}
+
+// IOError wraps an error of the file system, Op is the failed operation on the WAL file
+type IOError struct {
+	Op  string
+	Err error
+}
+
+func (e *IOError) Error() string {
+	return fmt.Sprintf("%s wal file: %v", e.Op, e.Err)
+}
+
+func (e *IOError) Unwrap() error {
+	return e.Err
+}
+
+// newIOError returns nil if err is nil
+func newIOError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &IOError{Op: op, Err: err}
+}
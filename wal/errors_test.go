@@ -0,0 +1,76 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksumError(t *testing.T) {
+	err := error(&ChecksumError{Kind: DataKindPage, PageNum: 3})
+	assert.Equal(t, "mismatch page checksum: page 3", err.Error())
+	assert.Equal(t, true, errors.Is(err, ErrChecksumMismatch))
+	assert.Equal(t, false, errors.Is(err, ErrUnsupportedVersion))
+
+	// wrapped
+	err = fmt.Errorf("recover: %w", &ChecksumError{Kind: DataKindEntry, PageNum: 4, LSN: 1500})
+	assert.Equal(t, "recover: mismatch entry checksum: entry at lsn 1500: page 4", err.Error())
+	assert.Equal(t, true, errors.Is(err, ErrChecksumMismatch))
+
+	var checksumErr *ChecksumError
+	assert.Equal(t, true, errors.As(err, &checksumErr))
+	assert.Equal(t, LSN(1500), checksumErr.LSN)
+	assert.Equal(t, PageNum(4), checksumErr.PageNum)
+}
+
+func TestOpen__Errors_Of_Master_Page(t *testing.T) {
+	writeMasterPages := func(t *testing.T, fn func(page *MasterPage)) *walTest {
+		w := newWalTest(t, 10, 4)
+		masterPage := w.readMasterPage(t)
+		w.wal.Shutdown()
+
+		file, err := w.fs.OpenFile(w.filename)
+		require.Equal(t, nil, err)
+		defer func() { _ = file.Close() }()
+
+		// write both slots
+		fn(&masterPage)
+		for i := 0; i < masterPageNumSlots; i++ {
+			masterPage.Sequence++
+			require.Equal(t, nil, WriteMasterPageSlot(file, &masterPage))
+		}
+		return w
+	}
+
+	t.Run("unknown checksum algorithm", func(t *testing.T) {
+		w := writeMasterPages(t, func(page *MasterPage) {
+			page.Checksum = 2
+		})
+		_, err := NewWAL(w.fs, w.filename, testPageSize*10, testPageSize*4)
+		assert.Equal(t, true, errors.Is(err, ErrIncompatibleFile))
+	})
+
+	t.Run("invalid page size log", func(t *testing.T) {
+		w := writeMasterPages(t, func(page *MasterPage) {
+			page.PageSizeLog = 20
+		})
+		_, err := NewWAL(w.fs, w.filename, testPageSize*10, testPageSize*4)
+		assert.Equal(t, true, errors.Is(err, ErrInvalidPageSize))
+	})
+}
+
+func TestIOError(t *testing.T) {
+	assert.Equal(t, nil, newIOError("write", nil))
+
+	syncErr := errors.New("sync error")
+	err := newIOError("sync", syncErr)
+	assert.Equal(t, "sync wal file: sync error", err.Error())
+	assert.Equal(t, true, errors.Is(err, syncErr))
+
+	var ioErr *IOError
+	assert.Equal(t, true, errors.As(err, &ioErr))
+	assert.Equal(t, "sync", ioErr.Op)
+}
@@ -0,0 +1,216 @@
+package filesys
+
+import (
+	"io"
+	"math/rand"
+	"sync"
+)
+
+// FaultOp is a kind of call that can fail with an injected error
+type FaultOp int
+
+const (
+	FaultOpFallocate FaultOp = iota + 1 // CreateEmptyFile
+	FaultOpRename
+	FaultOpWrite // File.WriteAt
+	FaultOpSync  // File.Sync and File.Datasync
+	FaultOpSyncDir
+)
+
+// CrashMode specifies which writes after the last fsync are persisted when crashing
+type CrashMode int
+
+const (
+	// CrashDropUnsynced drops all writes after the last fsync
+	CrashDropUnsynced CrashMode = iota
+
+	// CrashReorderUnsynced persists a random subset of the writes after the last fsync,
+	// applied in a random order
+	CrashReorderUnsynced
+
+	// CrashTornWrites is like CrashReorderUnsynced, but only a random subset of the sectors
+	// of each persisted write is persisted
+	CrashTornWrites
+)
+
+// FaultFileSystem wraps a MemFileSystem to fail the calls with injected errors
+// and to simulate power loss
+type FaultFileSystem struct {
+	mem        *MemFileSystem
+	sectorSize int64
+
+	mut    sync.Mutex
+	rand   *rand.Rand
+	faults map[FaultOp]*injectedFault
+}
+
+var _ FileSystem = &FaultFileSystem{}
+
+type injectedFault struct {
+	skip int // number of successful calls before failing
+	err  error
+}
+
+// NewFaultFileSystem creates the file system, writes are torn at multiples of sectorSize.
+// The random choices when crashing are decided by seed
+func NewFaultFileSystem(mem *MemFileSystem, sectorSize int64, seed int64) *FaultFileSystem {
+	return newFaultFileSystem(mem, sectorSize, rand.New(rand.NewSource(seed)))
+}
+
+func newFaultFileSystem(mem *MemFileSystem, sectorSize int64, r *rand.Rand) *FaultFileSystem {
+	return &FaultFileSystem{
+		mem:        mem,
+		sectorSize: sectorSize,
+		rand:       r,
+		faults:     map[FaultOp]*injectedFault{},
+	}
+}
+
+// Mem returns the underlying in-memory file system
+func (fs *FaultFileSystem) Mem() *MemFileSystem {
+	return fs.mem
+}
+
+// InjectError makes the call of op after skip successful calls fail with err.
+// The error is only returned once and replaces the previous injected error of op
+func (fs *FaultFileSystem) InjectError(op FaultOp, skip int, err error) {
+	fs.mut.Lock()
+	defer fs.mut.Unlock()
+
+	fs.faults[op] = &injectedFault{skip: skip, err: err}
+}
+
+func (fs *FaultFileSystem) checkFault(op FaultOp) error {
+	fs.mut.Lock()
+	defer fs.mut.Unlock()
+
+	fault, ok := fs.faults[op]
+	if !ok {
+		return nil
+	}
+	if fault.skip > 0 {
+		fault.skip--
+		return nil
+	}
+	delete(fs.faults, op)
+	return fault.err
+}
+
+// Crash returns a new file system with the state after a power loss.
+// Directory entries that are not synced are lost,
+// the writes after the last fsync are persisted depending on mode
+func (fs *FaultFileSystem) Crash(mode CrashMode) *FaultFileSystem {
+	mem := fs.mem.crash(func(synced []byte, pending []memWrite) []byte {
+		return fs.persistUnsynced(mode, synced, pending)
+	})
+	return newFaultFileSystem(mem, fs.sectorSize, fs.rand)
+}
+
+func (fs *FaultFileSystem) persistUnsynced(mode CrashMode, data []byte, pending []memWrite) []byte {
+	if mode == CrashDropUnsynced {
+		return data
+	}
+
+	fs.mut.Lock()
+	defer fs.mut.Unlock()
+
+	for _, index := range fs.rand.Perm(len(pending)) {
+		if fs.rand.Intn(2) == 0 {
+			continue
+		}
+
+		w := pending[index]
+		if mode == CrashTornWrites && !w.isTruncate {
+			data = fs.applyTornWrite(data, w)
+		} else {
+			data = w.apply(data)
+		}
+	}
+	return data
+}
+
+// applyTornWrite applies a random subset of the sectors of the write
+func (fs *FaultFileSystem) applyTornWrite(data []byte, w memWrite) []byte {
+	offset := w.offset
+	remain := w.data
+	for len(remain) > 0 {
+		n := min(int64(len(remain)), fs.sectorSize-offset%fs.sectorSize)
+		if fs.rand.Intn(2) == 1 {
+			data = memWrite{offset: offset, data: remain[:n]}.apply(data)
+		}
+		offset += n
+		remain = remain[n:]
+	}
+	return data
+}
+
+func (fs *FaultFileSystem) Exists(path string) (bool, error) {
+	return fs.mem.Exists(path)
+}
+
+func (fs *FaultFileSystem) CreateEmptyFile(name string, fileSize int64) (File, error) {
+	if err := fs.checkFault(FaultOpFallocate); err != nil {
+		return nil, err
+	}
+	file, err := fs.mem.CreateEmptyFile(name, fileSize)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{fs: fs, File: file}, nil
+}
+
+func (fs *FaultFileSystem) OpenFile(name string) (File, error) {
+	file, err := fs.mem.OpenFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{fs: fs, File: file}, nil
+}
+
+func (fs *FaultFileSystem) Rename(oldPath, newPath string) error {
+	if err := fs.checkFault(FaultOpRename); err != nil {
+		return err
+	}
+	return fs.mem.Rename(oldPath, newPath)
+}
+
+func (fs *FaultFileSystem) Remove(path string) error {
+	return fs.mem.Remove(path)
+}
+
+func (fs *FaultFileSystem) SyncDir(dir string) error {
+	if err := fs.checkFault(FaultOpSyncDir); err != nil {
+		return err
+	}
+	return fs.mem.SyncDir(dir)
+}
+
+func (fs *FaultFileSystem) LockFile(name string) (io.Closer, error) {
+	return fs.mem.LockFile(name)
+}
+
+type faultFile struct {
+	fs *FaultFileSystem
+	File
+}
+
+func (f *faultFile) WriteAt(p []byte, off int64) (int, error) {
+	if err := f.fs.checkFault(FaultOpWrite); err != nil {
+		return 0, err
+	}
+	return f.File.WriteAt(p, off)
+}
+
+func (f *faultFile) Sync() error {
+	if err := f.fs.checkFault(FaultOpSync); err != nil {
+		return err
+	}
+	return f.File.Sync()
+}
+
+func (f *faultFile) Datasync() error {
+	if err := f.fs.checkFault(FaultOpSync); err != nil {
+		return err
+	}
+	return f.File.Datasync()
+}
@@ -0,0 +1,149 @@
+package filesys
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultFileSystem__Inject_Error(t *testing.T) {
+	fs := NewFaultFileSystem(NewMemFileSystem(), 4, 1)
+
+	fallocateErr := errors.New("fallocate error")
+	fs.InjectError(FaultOpFallocate, 0, fallocateErr)
+
+	_, err := fs.CreateEmptyFile("/dir/file01", 8)
+	assert.Equal(t, fallocateErr, err)
+
+	existed, _ := fs.Exists("/dir/file01")
+	assert.Equal(t, false, existed)
+
+	// only fail once
+	file, err := fs.CreateEmptyFile("/dir/file01", 8)
+	assert.Equal(t, nil, err)
+
+	// fail the second write
+	writeErr := errors.New("write error")
+	fs.InjectError(FaultOpWrite, 1, writeErr)
+
+	_, err = file.WriteAt([]byte("ab"), 0)
+	assert.Equal(t, nil, err)
+	_, err = file.WriteAt([]byte("cd"), 2)
+	assert.Equal(t, writeErr, err)
+	_, err = file.WriteAt([]byte("ef"), 4)
+	assert.Equal(t, nil, err)
+
+	data, err := fs.Mem().ReadFile("/dir/file01")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "ab\x00\x00ef\x00\x00", string(data))
+
+	// sync errors
+	syncErr := errors.New("sync error")
+	fs.InjectError(FaultOpSync, 0, syncErr)
+	assert.Equal(t, syncErr, file.Datasync())
+	fs.InjectError(FaultOpSync, 0, syncErr)
+	assert.Equal(t, syncErr, file.Sync())
+	assert.Equal(t, nil, file.Sync())
+
+	synced, err := fs.Mem().ReadSyncedFile("/dir/file01")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "ab\x00\x00ef\x00\x00", string(synced))
+
+	// rename & sync dir errors
+	renameErr := errors.New("rename error")
+	fs.InjectError(FaultOpRename, 0, renameErr)
+	assert.Equal(t, renameErr, fs.Rename("/dir/file01", "/dir/file02"))
+
+	syncDirErr := errors.New("sync dir error")
+	fs.InjectError(FaultOpSyncDir, 0, syncDirErr)
+	assert.Equal(t, syncDirErr, fs.SyncDir("/dir"))
+
+	existed, _ = fs.Exists("/dir/file01")
+	assert.Equal(t, true, existed)
+
+	// the directory entry is not synced
+	existed, _ = fs.Crash(CrashDropUnsynced).Exists("/dir/file01")
+	assert.Equal(t, false, existed)
+}
+
+func newCrashTestFile(t *testing.T, seed int64) (*FaultFileSystem, File) {
+	fs := NewFaultFileSystem(NewMemFileSystem(), 2, seed)
+
+	file, err := fs.CreateEmptyFile("/dir/file01", 8)
+	require.Equal(t, nil, err)
+	require.Equal(t, nil, file.Sync())
+	require.Equal(t, nil, fs.SyncDir("/dir"))
+	return fs, file
+}
+
+func crashAndRead(t *testing.T, fs *FaultFileSystem, mode CrashMode) string {
+	data, err := fs.Crash(mode).Mem().ReadFile("/dir/file01")
+	require.Equal(t, nil, err)
+	return string(data)
+}
+
+func TestFaultFileSystem__Crash__Drop_Unsynced(t *testing.T) {
+	fs, file := newCrashTestFile(t, 1)
+
+	_, err := file.WriteAt([]byte("ab"), 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, file.Datasync())
+
+	_, err = file.WriteAt([]byte("cd"), 2)
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, "ab\x00\x00\x00\x00\x00\x00", crashAndRead(t, fs, CrashDropUnsynced))
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestFaultFileSystem__Crash__Reorder_Unsynced(t *testing.T) {
+	results := map[string]int{}
+	for seed := int64(0); seed < 100; seed++ {
+		fs, file := newCrashTestFile(t, seed)
+
+		_, err := file.WriteAt([]byte("abcd"), 0)
+		assert.Equal(t, nil, err)
+		_, err = file.WriteAt([]byte("XY"), 2)
+		assert.Equal(t, nil, err)
+
+		results[crashAndRead(t, fs, CrashReorderUnsynced)]++
+	}
+
+	// the later write can be persisted without the earlier one, or be overwritten by it
+	assert.Equal(t, []string{
+		"\x00\x00\x00\x00\x00\x00\x00\x00",
+		"\x00\x00XY\x00\x00\x00\x00",
+		"abXY\x00\x00\x00\x00",
+		"abcd\x00\x00\x00\x00",
+	}, sortedKeys(results))
+}
+
+func TestFaultFileSystem__Crash__Torn_Writes(t *testing.T) {
+	results := map[string]int{}
+	for seed := int64(0); seed < 100; seed++ {
+		fs, file := newCrashTestFile(t, seed)
+
+		_, err := file.WriteAt([]byte("abcde"), 1)
+		assert.Equal(t, nil, err)
+
+		results[crashAndRead(t, fs, CrashTornWrites)]++
+	}
+
+	// torn at the sector boundaries: 2, 4
+	assert.Equal(t, 8, len(results))
+	assert.Equal(t, true, results["\x00a\x00\x00\x00\x00\x00\x00"] > 0)
+	assert.Equal(t, true, results["\x00\x00bc\x00\x00\x00\x00"] > 0)
+	assert.Equal(t, true, results["\x00abcde\x00\x00"] > 0)
+	assert.Equal(t, true, results["\x00a\x00\x00de\x00\x00"] > 0)
+}
@@ -1,15 +1,51 @@
 package filesys
 
 import (
+	"errors"
 	"io"
 	"os"
 	"syscall"
 )
 
+// FileSystem is used by the WAL for all of its I/O operations
 type FileSystem interface {
 	Exists(path string) (bool, error)
-	CreateEmptyFile(name string, fileSize int64) (io.WriteCloser, error)
+
+	// CreateEmptyFile creates (or truncates) the file with size = fileSize, filled with zeros
+	CreateEmptyFile(name string, fileSize int64) (File, error)
+
+	// OpenFile opens an existing file for reading & writing
+	OpenFile(name string) (File, error)
+
 	Rename(oldPath, newPath string) error
+	Remove(path string) error
+
+	// SyncDir fsyncs the directory, makes the creating, renaming & removing of its entries durable
+	SyncDir(dir string) error
+
+	// LockFile takes an exclusive advisory lock on the file, creates it if not exists.
+	// Returns ErrLocked without blocking if the lock is already held,
+	// by another process or by another LockFile call in this process.
+	// The lock is released by closing the returned closer
+	LockFile(name string) (io.Closer, error)
+}
+
+// ErrLocked is returned by LockFile when the lock is already held
+var ErrLocked = errors.New("file is locked by another process")
+
+// File is an opened file that supports reading & writing at specific offsets
+type File interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+
+	// Sync flushes the data & metadata of the file to disk
+	Sync() error
+
+	// Datasync flushes the data and only the metadata that is needed to read the data back
+	Datasync() error
+
+	Truncate(size int64) error
 }
 
 func NewFileSystem() FileSystem {
@@ -29,8 +65,8 @@ func (f *fileSystemImpl) Exists(path string) (bool, error) {
 	return true, nil
 }
 
-func (f *fileSystemImpl) CreateEmptyFile(name string, fileSize int64) (io.WriteCloser, error) {
-	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+func (f *fileSystemImpl) CreateEmptyFile(name string, fileSize int64) (File, error) {
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
 	if err != nil {
 		return nil, err
 	}
@@ -41,9 +77,60 @@ func (f *fileSystemImpl) CreateEmptyFile(name string, fileSize int64) (io.WriteC
 		return nil, err
 	}
 
-	return file, nil
+	return &fileImpl{File: file}, nil
 }
 
 func (f *fileSystemImpl) Rename(oldPath, newPath string) error {
 	return os.Rename(oldPath, newPath)
 }
+
+func (f *fileSystemImpl) OpenFile(name string) (File, error) {
+	file, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &fileImpl{File: file}, nil
+}
+
+func (f *fileSystemImpl) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (f *fileSystemImpl) SyncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (f *fileSystemImpl) LockFile(name string) (io.Closer, error) {
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	// flock locks are owned by the open file description, so they also conflict inside a process
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+
+	// closing the file releases the lock
+	return file, nil
+}
+
+type fileImpl struct {
+	*os.File
+}
+
+func (f *fileImpl) Datasync() error {
+	return syscall.Fdatasync(int(f.Fd()))
+}
@@ -51,3 +51,116 @@ func TestFileSystem__Exists_And_Create_Empty_File(t *testing.T) {
 	assert.Equal(t, nil, err)
 	assert.Equal(t, true, existed)
 }
+
+func TestFileSystem__Open_File__Write_At(t *testing.T) {
+	tempDir := t.TempDir()
+	filename := filepath.Join(tempDir, "file01")
+
+	fs := NewFileSystem()
+
+	writer, err := fs.CreateEmptyFile(filename, 16)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, writer.Close())
+
+	// open and write
+	file, err := fs.OpenFile(filename)
+	assert.Equal(t, nil, err)
+
+	n, err := file.WriteAt([]byte("abcd"), 5)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, n)
+
+	assert.Equal(t, nil, file.Sync())
+
+	// read at
+	buf := make([]byte, 6)
+	n, err = file.ReadAt(buf, 4)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 6, n)
+	assert.Equal(t, "\x00abcd\x00", string(buf))
+
+	assert.Equal(t, nil, file.Close())
+
+	// check content
+	data, err := os.ReadFile(filename)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "\x00\x00\x00\x00\x00abcd\x00\x00\x00\x00\x00\x00\x00", string(data))
+
+	// open not existed file
+	_, err = fs.OpenFile(filepath.Join(tempDir, "file02"))
+	assert.Equal(t, true, os.IsNotExist(err))
+}
+
+func TestFileSystem__Datasync_And_Truncate(t *testing.T) {
+	tempDir := t.TempDir()
+	filename := filepath.Join(tempDir, "file01")
+
+	fs := NewFileSystem()
+
+	file, err := fs.CreateEmptyFile(filename, 16)
+	assert.Equal(t, nil, err)
+
+	_, err = file.WriteAt([]byte("abcd"), 2)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, file.Datasync())
+
+	// truncate
+	assert.Equal(t, nil, file.Truncate(4))
+	assert.Equal(t, nil, file.Sync())
+	assert.Equal(t, nil, file.Close())
+
+	data, err := os.ReadFile(filename)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "\x00\x00ab", string(data))
+}
+
+func TestFileSystem__Remove_And_Sync_Dir(t *testing.T) {
+	tempDir := t.TempDir()
+	filename := filepath.Join(tempDir, "file01")
+
+	fs := NewFileSystem()
+
+	file, err := fs.CreateEmptyFile(filename, 16)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, file.Close())
+	assert.Equal(t, nil, fs.SyncDir(tempDir))
+
+	// remove
+	assert.Equal(t, nil, fs.Remove(filename))
+	assert.Equal(t, nil, fs.SyncDir(tempDir))
+
+	existed, err := fs.Exists(filename)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, existed)
+
+	// remove not existed file
+	err = fs.Remove(filename)
+	assert.Equal(t, true, os.IsNotExist(err))
+
+	// sync not existed dir
+	err = fs.SyncDir(filepath.Join(tempDir, "dir01"))
+	assert.Equal(t, true, os.IsNotExist(err))
+}
+
+func TestFileSystem__Lock_File(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "file01.lock")
+	fs := NewFileSystem()
+
+	lock, err := fs.LockFile(filename)
+	assert.Equal(t, nil, err)
+
+	// the lock file is created
+	existed, err := fs.Exists(filename)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, existed)
+
+	// already locked
+	_, err = fs.LockFile(filename)
+	assert.Equal(t, ErrLocked, err)
+
+	// lock again after released
+	assert.Equal(t, nil, lock.Close())
+	lock, err = fs.LockFile(filename)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, lock.Close())
+}
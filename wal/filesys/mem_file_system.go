@@ -0,0 +1,340 @@
+package filesys
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// MemFileSystem is an in-memory FileSystem for testing.
+// Besides the current content of files, it keeps the synced state:
+// the file content after the last Sync / Datasync
+// and the directory entries after the last SyncDir.
+// Crash returns the file system that only contains the synced state
+type MemFileSystem struct {
+	mut sync.Mutex
+
+	files       map[string]*memInode // current directory entries
+	syncedFiles map[string]*memInode // directory entries after the last SyncDir
+	locks       map[string]struct{}  // names of the locked files, not kept after crashing
+}
+
+var _ FileSystem = &MemFileSystem{}
+
+// memInode is the content of a file, can be referenced by many names
+type memInode struct {
+	data    []byte     // current content
+	synced  []byte     // content after the last fsync
+	pending []memWrite // writes & truncates after the last fsync, in order
+}
+
+// memWrite is a write or a truncate (if isTruncate = true) that is not yet synced
+type memWrite struct {
+	offset     int64
+	data       []byte
+	isTruncate bool
+	size       int64
+}
+
+// apply returns the content after applying the write to data
+func (w memWrite) apply(data []byte) []byte {
+	if w.isTruncate {
+		return resizeBytes(data, w.size)
+	}
+	end := w.offset + int64(len(w.data))
+	if end > int64(len(data)) {
+		data = resizeBytes(data, end)
+	}
+	copy(data[w.offset:], w.data)
+	return data
+}
+
+func resizeBytes(data []byte, size int64) []byte {
+	if size <= int64(len(data)) {
+		return data[:size:size]
+	}
+	return append(data, make([]byte, size-int64(len(data)))...)
+}
+
+func NewMemFileSystem() *MemFileSystem {
+	return &MemFileSystem{
+		files:       map[string]*memInode{},
+		syncedFiles: map[string]*memInode{},
+		locks:       map[string]struct{}{},
+	}
+}
+
+func (fs *MemFileSystem) Exists(path string) (bool, error) {
+	fs.mut.Lock()
+	defer fs.mut.Unlock()
+
+	_, ok := fs.files[filepath.Clean(path)]
+	return ok, nil
+}
+
+func (fs *MemFileSystem) CreateEmptyFile(name string, fileSize int64) (File, error) {
+	fs.mut.Lock()
+	defer fs.mut.Unlock()
+
+	name = filepath.Clean(name)
+	inode, ok := fs.files[name]
+	if !ok {
+		inode = &memInode{}
+		fs.files[name] = inode
+	}
+	inode.data = make([]byte, fileSize)
+	inode.pending = append(inode.pending,
+		memWrite{isTruncate: true, size: 0},
+		memWrite{isTruncate: true, size: fileSize},
+	)
+
+	return &memFile{fs: fs, inode: inode, name: name}, nil
+}
+
+func (fs *MemFileSystem) OpenFile(name string) (File, error) {
+	fs.mut.Lock()
+	defer fs.mut.Unlock()
+
+	inode, ok := fs.files[filepath.Clean(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return &memFile{fs: fs, inode: inode, name: name}, nil
+}
+
+func (fs *MemFileSystem) Rename(oldPath, newPath string) error {
+	fs.mut.Lock()
+	defer fs.mut.Unlock()
+
+	oldPath = filepath.Clean(oldPath)
+	inode, ok := fs.files[oldPath]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: os.ErrNotExist}
+	}
+
+	delete(fs.files, oldPath)
+	fs.files[filepath.Clean(newPath)] = inode
+	return nil
+}
+
+func (fs *MemFileSystem) Remove(path string) error {
+	fs.mut.Lock()
+	defer fs.mut.Unlock()
+
+	path = filepath.Clean(path)
+	if _, ok := fs.files[path]; !ok {
+		return &os.PathError{Op: "remove", Path: path, Err: os.ErrNotExist}
+	}
+	delete(fs.files, path)
+	return nil
+}
+
+// SyncDir makes the current entries of the directory durable.
+// Every directory is considered existing
+func (fs *MemFileSystem) SyncDir(dir string) error {
+	fs.mut.Lock()
+	defer fs.mut.Unlock()
+
+	dir = filepath.Clean(dir)
+	for name := range fs.syncedFiles {
+		if filepath.Dir(name) == dir {
+			delete(fs.syncedFiles, name)
+		}
+	}
+	for name, inode := range fs.files {
+		if filepath.Dir(name) == dir {
+			fs.syncedFiles[name] = inode
+		}
+	}
+	return nil
+}
+
+// ReadFile returns the current content of the file
+func (fs *MemFileSystem) ReadFile(name string) ([]byte, error) {
+	fs.mut.Lock()
+	defer fs.mut.Unlock()
+
+	inode, ok := fs.files[filepath.Clean(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return cloneBytes(inode.data), nil
+}
+
+// ReadSyncedFile returns the content of the file after the last fsync
+func (fs *MemFileSystem) ReadSyncedFile(name string) ([]byte, error) {
+	fs.mut.Lock()
+	defer fs.mut.Unlock()
+
+	inode, ok := fs.files[filepath.Clean(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return cloneBytes(inode.synced), nil
+}
+
+// LockFile only keeps the lock in memory, the lock file is not created
+func (fs *MemFileSystem) LockFile(name string) (io.Closer, error) {
+	fs.mut.Lock()
+	defer fs.mut.Unlock()
+
+	name = filepath.Clean(name)
+	if _, ok := fs.locks[name]; ok {
+		return nil, ErrLocked
+	}
+	fs.locks[name] = struct{}{}
+	return &memLock{fs: fs, name: name}, nil
+}
+
+type memLock struct {
+	fs       *MemFileSystem
+	name     string
+	released bool
+}
+
+func (l *memLock) Close() error {
+	l.fs.mut.Lock()
+	defer l.fs.mut.Unlock()
+
+	if l.released {
+		return os.ErrClosed
+	}
+	l.released = true
+	delete(l.fs.locks, l.name)
+	return nil
+}
+
+// Crash returns a new file system that only contains the synced state of this file system,
+// as if the machine crashed and restarted.
+// Files opened on this file system are not affected
+func (fs *MemFileSystem) Crash() *MemFileSystem {
+	return fs.crash(func(synced []byte, pending []memWrite) []byte {
+		return synced
+	})
+}
+
+// crash returns a new file system with the synced directory entries.
+// persist computes the content of a file after crashing from its synced content
+// and its not yet synced writes
+func (fs *MemFileSystem) crash(persist func(synced []byte, pending []memWrite) []byte) *MemFileSystem {
+	fs.mut.Lock()
+	defer fs.mut.Unlock()
+
+	// sort the names to make the order of calling persist deterministic
+	names := make([]string, 0, len(fs.syncedFiles))
+	for name := range fs.syncedFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := NewMemFileSystem()
+	inodes := map[*memInode]*memInode{}
+	for _, name := range names {
+		inode := fs.syncedFiles[name]
+		newInode, ok := inodes[inode]
+		if !ok {
+			data := persist(cloneBytes(inode.synced), inode.pending)
+			newInode = &memInode{
+				data:   data,
+				synced: cloneBytes(data),
+			}
+			inodes[inode] = newInode
+		}
+		result.files[name] = newInode
+		result.syncedFiles[name] = newInode
+	}
+	return result
+}
+
+func cloneBytes(data []byte) []byte {
+	return append([]byte{}, data...)
+}
+
+type memFile struct {
+	fs     *MemFileSystem
+	inode  *memInode
+	name   string
+	closed bool
+}
+
+func (f *memFile) checkClosed(op string) error {
+	if f.closed {
+		return &os.PathError{Op: op, Path: f.name, Err: os.ErrClosed}
+	}
+	return nil
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fs.mut.Lock()
+	defer f.fs.mut.Unlock()
+
+	if err := f.checkClosed("read"); err != nil {
+		return 0, err
+	}
+	if off >= int64(len(f.inode.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, f.inode.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	f.fs.mut.Lock()
+	defer f.fs.mut.Unlock()
+
+	if err := f.checkClosed("write"); err != nil {
+		return 0, err
+	}
+
+	w := memWrite{offset: off, data: cloneBytes(p)}
+	f.inode.data = w.apply(f.inode.data)
+	f.inode.pending = append(f.inode.pending, w)
+	return len(p), nil
+}
+
+func (f *memFile) Sync() error {
+	f.fs.mut.Lock()
+	defer f.fs.mut.Unlock()
+
+	if err := f.checkClosed("sync"); err != nil {
+		return err
+	}
+	f.inode.synced = cloneBytes(f.inode.data)
+	f.inode.pending = nil
+	return nil
+}
+
+func (f *memFile) Datasync() error {
+	return f.Sync()
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.fs.mut.Lock()
+	defer f.fs.mut.Unlock()
+
+	if err := f.checkClosed("truncate"); err != nil {
+		return err
+	}
+
+	w := memWrite{isTruncate: true, size: size}
+	f.inode.data = w.apply(f.inode.data)
+	f.inode.pending = append(f.inode.pending, w)
+	return nil
+}
+
+func (f *memFile) Close() error {
+	f.fs.mut.Lock()
+	defer f.fs.mut.Unlock()
+
+	if err := f.checkClosed("close"); err != nil {
+		return err
+	}
+	f.closed = true
+	return nil
+}
@@ -0,0 +1,217 @@
+package filesys
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemFileSystem__Create_Write_Read(t *testing.T) {
+	fs := NewMemFileSystem()
+
+	existed, err := fs.Exists("/dir/file01")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, existed)
+
+	file, err := fs.CreateEmptyFile("/dir/file01", 8)
+	assert.Equal(t, nil, err)
+
+	existed, err = fs.Exists("/dir/file01")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, existed)
+
+	n, err := file.WriteAt([]byte("abcd"), 2)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 4, n)
+
+	// read at
+	buf := make([]byte, 6)
+	n, err = file.ReadAt(buf, 1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 6, n)
+	assert.Equal(t, "\x00abcd\x00", string(buf))
+
+	// read after the end of file
+	n, err = file.ReadAt(buf, 5)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 3, n)
+
+	// write after the end of file
+	_, err = file.WriteAt([]byte("xy"), 10)
+	assert.Equal(t, nil, err)
+
+	data, err := fs.ReadFile("/dir/file01")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "\x00\x00abcd\x00\x00\x00\x00xy", string(data))
+
+	// close
+	assert.Equal(t, nil, file.Close())
+	_, err = file.WriteAt([]byte("xy"), 0)
+	assert.Equal(t, true, errors.Is(err, os.ErrClosed))
+
+	// open not existed file
+	_, err = fs.OpenFile("/dir/file02")
+	assert.Equal(t, true, os.IsNotExist(err))
+}
+
+func TestMemFileSystem__Sync_And_Truncate(t *testing.T) {
+	fs := NewMemFileSystem()
+
+	file, err := fs.CreateEmptyFile("/dir/file01", 4)
+	assert.Equal(t, nil, err)
+
+	synced, err := fs.ReadSyncedFile("/dir/file01")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "", string(synced))
+
+	_, err = file.WriteAt([]byte("ab"), 1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, file.Datasync())
+
+	_, err = file.WriteAt([]byte("cd"), 2)
+	assert.Equal(t, nil, err)
+
+	synced, err = fs.ReadSyncedFile("/dir/file01")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "\x00ab\x00", string(synced))
+
+	// truncate
+	assert.Equal(t, nil, file.Truncate(3))
+	assert.Equal(t, nil, file.Sync())
+
+	synced, err = fs.ReadSyncedFile("/dir/file01")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "\x00ac", string(synced))
+
+	assert.Equal(t, nil, file.Truncate(5))
+	data, err := fs.ReadFile("/dir/file01")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "\x00ac\x00\x00", string(data))
+}
+
+func TestMemFileSystem__Rename_And_Remove(t *testing.T) {
+	fs := NewMemFileSystem()
+
+	file, err := fs.CreateEmptyFile("/dir/file01", 4)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, file.Close())
+
+	assert.Equal(t, nil, fs.Rename("/dir/file01", "/dir/file02"))
+
+	existed, _ := fs.Exists("/dir/file01")
+	assert.Equal(t, false, existed)
+	existed, _ = fs.Exists("/dir/file02")
+	assert.Equal(t, true, existed)
+
+	err = fs.Rename("/dir/file01", "/dir/file03")
+	assert.Equal(t, true, os.IsNotExist(err))
+
+	// remove
+	assert.Equal(t, nil, fs.Remove("/dir/file02"))
+	existed, _ = fs.Exists("/dir/file02")
+	assert.Equal(t, false, existed)
+
+	err = fs.Remove("/dir/file02")
+	assert.Equal(t, true, os.IsNotExist(err))
+}
+
+func TestMemFileSystem__Crash(t *testing.T) {
+	fs := NewMemFileSystem()
+
+	// synced file & entry
+	file, err := fs.CreateEmptyFile("/dir/file01", 4)
+	assert.Equal(t, nil, err)
+	_, err = file.WriteAt([]byte("ab"), 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, file.Sync())
+	assert.Equal(t, nil, fs.SyncDir("/dir"))
+
+	// not synced write
+	_, err = file.WriteAt([]byte("cd"), 2)
+	assert.Equal(t, nil, err)
+
+	// synced file, but the entry is not synced
+	file2, err := fs.CreateEmptyFile("/dir/file02", 4)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, file2.Sync())
+
+	// synced entry in other dir
+	_, err = fs.CreateEmptyFile("/dir2/file03", 4)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, fs.SyncDir("/dir2"))
+
+	newFS := fs.Crash()
+
+	data, err := newFS.ReadFile("/dir/file01")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "ab\x00\x00", string(data))
+
+	existed, _ := newFS.Exists("/dir/file02")
+	assert.Equal(t, false, existed)
+
+	data, err = newFS.ReadFile("/dir2/file03")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "", string(data))
+
+	// the old file system is not changed
+	data, err = fs.ReadFile("/dir/file01")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "abcd", string(data))
+}
+
+func TestMemFileSystem__Crash__Rename_Not_Synced(t *testing.T) {
+	fs := NewMemFileSystem()
+
+	file, err := fs.CreateEmptyFile("/dir/file01.tmp", 4)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, file.Sync())
+	assert.Equal(t, nil, fs.SyncDir("/dir"))
+
+	assert.Equal(t, nil, fs.Rename("/dir/file01.tmp", "/dir/file01"))
+
+	// rename is lost
+	newFS := fs.Crash()
+	existed, _ := newFS.Exists("/dir/file01")
+	assert.Equal(t, false, existed)
+	existed, _ = newFS.Exists("/dir/file01.tmp")
+	assert.Equal(t, true, existed)
+
+	// rename is durable
+	assert.Equal(t, nil, fs.SyncDir("/dir"))
+	newFS = fs.Crash()
+	existed, _ = newFS.Exists("/dir/file01")
+	assert.Equal(t, true, existed)
+	existed, _ = newFS.Exists("/dir/file01.tmp")
+	assert.Equal(t, false, existed)
+}
+
+func TestMemFileSystem__Lock_File(t *testing.T) {
+	fs := NewMemFileSystem()
+
+	lock, err := fs.LockFile("/dir/file01.lock")
+	assert.Equal(t, nil, err)
+
+	_, err = fs.LockFile("/dir/../dir/file01.lock")
+	assert.Equal(t, ErrLocked, err)
+
+	// other file
+	lock2, err := fs.LockFile("/dir/file02.lock")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, lock2.Close())
+
+	// the lock is not kept after crashing
+	lock3, err := fs.Crash().LockFile("/dir/file01.lock")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, lock3.Close())
+
+	// release
+	assert.Equal(t, nil, lock.Close())
+	assert.Equal(t, os.ErrClosed, lock.Close())
+
+	lock, err = fs.LockFile("/dir/file01.lock")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, lock.Close())
+}
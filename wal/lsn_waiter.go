@@ -0,0 +1,90 @@
+package wal
+
+import (
+	"container/heap"
+	"sync"
+
+	"github.com/QuangTung97/go-wal/wal/types"
+)
+
+// NewLSNWaiter creates a thread safe types.LSNWaiter.
+// Waiters are kept in a min heap ordered by lsn, so SetLSN only wakes up
+// the waiters with lsn <= the new lsn, in the increasing order of lsn
+func NewLSNWaiter(initLsn types.LSN) types.LSNWaiter {
+	return &lsnWaiterImpl{
+		current: initLsn,
+	}
+}
+
+type lsnWaiterImpl struct {
+	mut     sync.Mutex
+	current types.LSN
+	waiters lsnWaitHeap
+}
+
+type lsnWaitEntry struct {
+	lsn types.LSN
+	ch  chan struct{}
+}
+
+func (w *lsnWaiterImpl) WaitLSN(lsn types.LSN) {
+	w.mut.Lock()
+	if lsn <= w.current {
+		w.mut.Unlock()
+		return
+	}
+
+	entry := &lsnWaitEntry{
+		lsn: lsn,
+		ch:  make(chan struct{}),
+	}
+	heap.Push(&w.waiters, entry)
+	w.mut.Unlock()
+
+	<-entry.ch
+}
+
+// SetLSN is ignored if lsn is smaller than the current one
+func (w *lsnWaiterImpl) SetLSN(lsn types.LSN) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+
+	if lsn <= w.current {
+		return
+	}
+	w.current = lsn
+
+	for len(w.waiters) > 0 && w.waiters[0].lsn <= lsn {
+		entry := heap.Pop(&w.waiters).(*lsnWaitEntry)
+		close(entry.ch)
+	}
+}
+
+type lsnWaitHeap []*lsnWaitEntry
+
+var _ heap.Interface = &lsnWaitHeap{}
+
+func (h lsnWaitHeap) Len() int {
+	return len(h)
+}
+
+func (h lsnWaitHeap) Less(i, j int) bool {
+	return h[i].lsn < h[j].lsn
+}
+
+func (h lsnWaitHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *lsnWaitHeap) Push(x any) {
+	*h = append(*h, x.(*lsnWaitEntry))
+}
+
+func (h *lsnWaitHeap) Pop() any {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return entry
+}
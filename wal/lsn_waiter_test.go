@@ -0,0 +1,71 @@
+package wal
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/QuangTung97/go-wal/wal/types"
+)
+
+func (w *lsnWaiterImpl) waitForNumWaiters(n int) {
+	for {
+		w.mut.Lock()
+		num := len(w.waiters)
+		w.mut.Unlock()
+
+		if num == n {
+			return
+		}
+		runtime.Gosched()
+	}
+}
+
+func TestLSNWaiter__Wait_Smaller_LSN__Return_Immediately(t *testing.T) {
+	w := NewLSNWaiter(100)
+
+	w.WaitLSN(99)
+	w.WaitLSN(100)
+
+	w.SetLSN(120)
+	w.WaitLSN(120)
+
+	// set smaller is ignored
+	w.SetLSN(110)
+	w.WaitLSN(120)
+	assert.Equal(t, types.LSN(120), w.(*lsnWaiterImpl).current)
+}
+
+func TestLSNWaiter__Only_Wake_Up_Waiters_With_Smaller_LSN(t *testing.T) {
+	w := NewLSNWaiter(100).(*lsnWaiterImpl)
+
+	var mut sync.Mutex
+	var woken []types.LSN
+
+	var wg sync.WaitGroup
+	for _, lsn := range []types.LSN{130, 110, 150, 120} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.WaitLSN(lsn)
+
+			mut.Lock()
+			woken = append(woken, lsn)
+			mut.Unlock()
+		}()
+	}
+
+	w.waitForNumWaiters(4)
+
+	w.SetLSN(125)
+	w.waitForNumWaiters(2)
+	assert.Equal(t, types.LSN(130), w.waiters[0].lsn)
+
+	w.SetLSN(150)
+	wg.Wait()
+
+	assert.ElementsMatch(t, []types.LSN{110, 120, 130, 150}, woken)
+	assert.Equal(t, 0, len(w.waiters))
+}
@@ -2,7 +2,6 @@ package wal
 
 import (
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
@@ -12,14 +11,31 @@ import (
 // Format of master page
 // version: 1 byte
 // checksum: 4 bytes - crc32 (little endian)
-// latest generation number: 8 bytes (little endian)
+// latest epoch: 4 bytes (little endian)
 // checkpoint lsn: 8 bytes (little endian)
+// page size log: 1 byte
+// sequence: 8 bytes (little endian)
+// checksum algorithm of the pages and the entries: 1 byte
+// number of pages of the WAL file: 8 bytes (little endian)
+//
+// The master page has two slots of masterPageSize bytes at the start of the WAL file.
+// Its size does not depend on the page size, so it can be read before knowing the page size.
+// The slots are written alternately with increasing sequence numbers (slot = sequence % 2),
+// the valid slot with the highest sequence is the current master page.
+// A torn write of one slot when crashing never loses the previous master page
 // --------------------------------------------------------------------
 
 const (
-	masterPageChecksumOffset    = 1
-	masterPageLatestEpochOffset = masterPageChecksumOffset + 4
-	masterPageCheckpointOffset  = masterPageLatestEpochOffset + 4
+	masterPageChecksumOffset     = 1
+	masterPageLatestEpochOffset  = masterPageChecksumOffset + 4
+	masterPageCheckpointOffset   = masterPageLatestEpochOffset + 4
+	masterPageSizeLogOffset      = masterPageCheckpointOffset + 8
+	masterPageSequenceOffset     = masterPageSizeLogOffset + 1
+	masterPageChecksumAlgoOffset = masterPageSequenceOffset + 8
+	masterPageNumPageOffset      = masterPageChecksumAlgoOffset + 1
+
+	masterPageSize     = 1 << MinPageSizeLog
+	masterPageNumSlots = 2
 )
 
 type MasterPageVersion uint8
@@ -32,21 +48,35 @@ type MasterPage struct {
 	Version       MasterPageVersion
 	LatestEpoch   Epoch
 	CheckpointLSN LSN
+	PageSizeLog   uint8
+	Sequence      uint64
+	Checksum      ChecksumAlgorithm
+	NumPage       PageNum // number of pages of the WAL file, decides the ring of the log on disk
 }
 
 func WriteMasterPage(w io.Writer, page *MasterPage) error {
-	var data [PageSize]byte
+	var data [masterPageSize]byte
 
 	data[0] = byte(page.Version)
 
-	binary.LittleEndian.PutUint64(
+	binary.LittleEndian.PutUint32(
 		data[masterPageLatestEpochOffset:],
-		uint64(page.LatestEpoch.val),
+		page.LatestEpoch.val,
 	)
 	binary.LittleEndian.PutUint64(
 		data[masterPageCheckpointOffset:],
 		uint64(page.CheckpointLSN),
 	)
+	data[masterPageSizeLogOffset] = page.PageSizeLog
+	binary.LittleEndian.PutUint64(
+		data[masterPageSequenceOffset:],
+		page.Sequence,
+	)
+	data[masterPageChecksumAlgoOffset] = uint8(page.Checksum)
+	binary.LittleEndian.PutUint64(
+		data[masterPageNumPageOffset:],
+		uint64(page.NumPage),
+	)
 
 	// write checksum
 	crcSum := crc32.ChecksumIEEE(data[:])
@@ -60,7 +90,7 @@ func WriteMasterPage(w io.Writer, page *MasterPage) error {
 }
 
 func ReadMasterPage(r io.Reader, page *MasterPage) error {
-	var data [PageSize]byte
+	var data [masterPageSize]byte
 
 	if _, err := io.ReadFull(r, data[:]); err != nil {
 		return err
@@ -68,8 +98,7 @@ func ReadMasterPage(r io.Reader, page *MasterPage) error {
 
 	version := MasterPageVersion(data[0])
 	if version != MasterPageFirstVersion {
-		// TODO testing
-		return fmt.Errorf("invalid master page version: %d", version)
+		return &VersionError{Kind: DataKindMasterPage, Version: uint8(version)}
 	}
 
 	crcSum := binary.LittleEndian.Uint32(data[masterPageChecksumOffset:])
@@ -78,7 +107,17 @@ func ReadMasterPage(r io.Reader, page *MasterPage) error {
 
 	computedSum := crc32.ChecksumIEEE(data[:])
 	if computedSum != crcSum {
-		return errors.New("mismatch master page checksum")
+		return &ChecksumError{Kind: DataKindMasterPage}
+	}
+
+	pageSizeLog := data[masterPageSizeLogOffset]
+	if pageSizeLog < MinPageSizeLog || pageSizeLog > MaxPageSizeLog {
+		return fmt.Errorf("%w: page size log %d of master page", ErrInvalidPageSize, pageSizeLog)
+	}
+
+	checksum := ChecksumAlgorithm(data[masterPageChecksumAlgoOffset])
+	if !checksum.isValid() {
+		return fmt.Errorf("%w: unknown checksum algorithm %d of master page", ErrIncompatibleFile, checksum)
 	}
 
 	latestGen := binary.LittleEndian.Uint32(data[masterPageLatestEpochOffset:])
@@ -88,7 +127,58 @@ func ReadMasterPage(r io.Reader, page *MasterPage) error {
 		Version:       MasterPageVersion(data[0]),
 		LatestEpoch:   NewEpoch(latestGen),
 		CheckpointLSN: LSN(checkpoint),
+		PageSizeLog:   pageSizeLog,
+		Sequence:      binary.LittleEndian.Uint64(data[masterPageSequenceOffset:]),
+		Checksum:      checksum,
+		NumPage:       PageNum(binary.LittleEndian.Uint64(data[masterPageNumPageOffset:])),
+	}
+
+	return nil
+}
+
+// masterNumPageOf returns the number of pages at the start of the WAL file
+// that store the slots of the master page
+func masterNumPageOf(pageSize int64) PageNum {
+	return PageNum(max(1, masterPageNumSlots*masterPageSize/pageSize))
+}
+
+// masterPageSlotOffset returns the offset in the WAL file of the slot storing the master page
+func masterPageSlotOffset(sequence uint64) int64 {
+	return int64(sequence%masterPageNumSlots) * masterPageSize
+}
+
+// WriteMasterPageSlot writes the master page to its slot, decided by its sequence
+func WriteMasterPageSlot(w io.WriterAt, page *MasterPage) error {
+	return WriteMasterPage(io.NewOffsetWriter(w, masterPageSlotOffset(page.Sequence)), page)
+}
+
+// ReadLatestMasterPage reads all slots and returns the valid master page with the highest sequence.
+// Returns the error of the first slot if no slot is valid
+func ReadLatestMasterPage(r io.ReaderAt, page *MasterPage) error {
+	var firstErr error
+	found := false
+	for slot := uint64(0); slot < masterPageNumSlots; slot++ {
+		var slotPage MasterPage
+		reader := io.NewSectionReader(r, masterPageSlotOffset(slot), masterPageSize)
+		if err := ReadMasterPage(reader, &slotPage); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		if slotPage.Sequence%masterPageNumSlots != slot {
+			continue
+		}
+
+		if !found || slotPage.Sequence > page.Sequence {
+			*page = slotPage
+			found = true
+		}
 	}
 
+	if !found {
+		return firstErr
+	}
 	return nil
 }
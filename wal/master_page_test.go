@@ -3,6 +3,7 @@ package wal
 import (
 	"bytes"
 	"errors"
+	"io"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -16,6 +17,11 @@ func TestMasterPageHeaderOffset(t *testing.T) {
 	assert.Equal(t, 1, masterPageChecksumOffset)
 	assert.Equal(t, 5, masterPageLatestEpochOffset)
 	assert.Equal(t, 9, masterPageCheckpointOffset)
+	assert.Equal(t, 17, masterPageSizeLogOffset)
+	assert.Equal(t, 18, masterPageSequenceOffset)
+	assert.Equal(t, 26, masterPageChecksumAlgoOffset)
+	assert.Equal(t, 27, masterPageNumPageOffset)
+	assert.Equal(t, 512, masterPageSize)
 }
 
 func TestReadMasterPage__Write_And_Read(t *testing.T) {
@@ -24,7 +30,10 @@ func TestReadMasterPage__Write_And_Read(t *testing.T) {
 	page := MasterPage{
 		Version:       MasterPageFirstVersion,
 		LatestEpoch:   NewEpoch(31),
-		CheckpointLSN: PageSize*3 + 123,
+		CheckpointLSN: testPageSize*3 + 123,
+		PageSizeLog:   12,
+		Sequence:      1<<40 + 7,
+		NumPage:       1<<33 + 5,
 	}
 
 	// write
@@ -42,5 +51,170 @@ func TestReadMasterPage__Write_And_Read(t *testing.T) {
 
 	pageData[511] = 11
 	err = ReadMasterPage(bytes.NewReader(pageData), &readPage)
-	assert.Equal(t, errors.New("mismatch master page checksum"), err)
+	assert.Equal(t, &ChecksumError{Kind: DataKindMasterPage}, err)
+	assert.Equal(t, true, errors.Is(err, ErrChecksumMismatch))
+	assert.Equal(t, "mismatch master page checksum", err.Error())
+}
+
+func newMasterPageSlots(t *testing.T, pages ...MasterPage) []byte {
+	data := make([]byte, masterPageNumSlots*masterPageSize)
+	for _, page := range pages {
+		err := WriteMasterPageSlot(bytesWriterAt(data), &page)
+		assert.Equal(t, nil, err)
+	}
+	return data
+}
+
+type bytesWriterAt []byte
+
+func (b bytesWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	return copy(b[off:], p), nil
+}
+
+func TestReadLatestMasterPage(t *testing.T) {
+	page1 := MasterPage{
+		Version:       MasterPageFirstVersion,
+		CheckpointLSN: 1000,
+		PageSizeLog:   9,
+		Sequence:      4,
+	}
+	page2 := page1
+	page2.CheckpointLSN = 2000
+	page2.Sequence = 5
+
+	t.Run("highest sequence", func(t *testing.T) {
+		data := newMasterPageSlots(t, page1, page2)
+
+		var page MasterPage
+		assert.Equal(t, nil, ReadLatestMasterPage(bytes.NewReader(data), &page))
+		assert.Equal(t, page2, page)
+
+		// write slot 0 again
+		page3 := page2
+		page3.CheckpointLSN = 3000
+		page3.Sequence = 6
+		data = newMasterPageSlots(t, page1, page2, page3)
+
+		assert.Equal(t, nil, ReadLatestMasterPage(bytes.NewReader(data), &page))
+		assert.Equal(t, page3, page)
+	})
+
+	t.Run("only one slot written", func(t *testing.T) {
+		data := newMasterPageSlots(t, page1)
+
+		var page MasterPage
+		assert.Equal(t, nil, ReadLatestMasterPage(bytes.NewReader(data), &page))
+		assert.Equal(t, page1, page)
+	})
+
+	t.Run("torn latest slot", func(t *testing.T) {
+		data := newMasterPageSlots(t, page1, page2)
+		data[masterPageSize+masterPageCheckpointOffset]++
+
+		var page MasterPage
+		assert.Equal(t, nil, ReadLatestMasterPage(bytes.NewReader(data), &page))
+		assert.Equal(t, page1, page)
+	})
+
+	t.Run("sequence not match slot", func(t *testing.T) {
+		data := newMasterPageSlots(t, page1)
+
+		// page with odd sequence in slot 0
+		err := WriteMasterPage(bytes.NewBuffer(data[:0]), &page2)
+		assert.Equal(t, nil, err)
+
+		var page MasterPage
+		err = ReadLatestMasterPage(bytes.NewReader(data), &page)
+		assert.Equal(t, &VersionError{Kind: DataKindMasterPage, Version: 0}, err)
+	})
+
+	t.Run("no valid slot", func(t *testing.T) {
+		data := newMasterPageSlots(t, page1, page2)
+		data[masterPageCheckpointOffset]++
+		data[masterPageSize+masterPageCheckpointOffset]++
+
+		var page MasterPage
+		err := ReadLatestMasterPage(bytes.NewReader(data), &page)
+		assert.Equal(t, &ChecksumError{Kind: DataKindMasterPage}, err)
+	})
+}
+
+func TestReadMasterPage__Malformed(t *testing.T) {
+	newPageData := func(page MasterPage) []byte {
+		var buf bytes.Buffer
+		assert.Equal(t, nil, WriteMasterPage(&buf, &page))
+		return buf.Bytes()
+	}
+
+	t.Run("invalid version", func(t *testing.T) {
+		data := newPageData(MasterPage{Version: 2, PageSizeLog: 9})
+
+		var page MasterPage
+		err := ReadMasterPage(bytes.NewReader(data), &page)
+		assert.Equal(t, true, errors.Is(err, ErrUnsupportedVersion))
+		assert.Equal(t, "unsupported master page version: 2", err.Error())
+	})
+
+	t.Run("invalid page size log", func(t *testing.T) {
+		data := newPageData(MasterPage{Version: MasterPageFirstVersion, PageSizeLog: 17})
+
+		var page MasterPage
+		err := ReadMasterPage(bytes.NewReader(data), &page)
+		assert.Equal(t, true, errors.Is(err, ErrInvalidPageSize))
+		assert.Equal(t, "invalid page size: page size log 17 of master page", err.Error())
+	})
+
+	t.Run("unknown checksum algorithm", func(t *testing.T) {
+		data := newPageData(MasterPage{Version: MasterPageFirstVersion, PageSizeLog: 9, Checksum: 2})
+
+		var page MasterPage
+		err := ReadMasterPage(bytes.NewReader(data), &page)
+		assert.Equal(t, true, errors.Is(err, ErrIncompatibleFile))
+		assert.Equal(t, "incompatible wal file: unknown checksum algorithm 2 of master page", err.Error())
+	})
+
+	t.Run("not enough data", func(t *testing.T) {
+		data := newPageData(MasterPage{Version: MasterPageFirstVersion, PageSizeLog: 9})
+
+		var page MasterPage
+		err := ReadMasterPage(bytes.NewReader(data[:100]), &page)
+		assert.Equal(t, io.ErrUnexpectedEOF, err)
+	})
+}
+
+func FuzzReadMasterPage(f *testing.F) {
+	var buf bytes.Buffer
+	err := WriteMasterPage(&buf, &MasterPage{
+		Version:       MasterPageFirstVersion,
+		LatestEpoch:   NewEpoch(3),
+		CheckpointLSN: 1000,
+		PageSizeLog:   12,
+		Sequence:      5,
+	})
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(buf.Bytes())
+	f.Add(make([]byte, masterPageSize))
+	f.Add([]byte{1})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var page MasterPage
+		if err := ReadLatestMasterPage(bytes.NewReader(data), &page); err != nil {
+			return
+		}
+
+		// the master page is read back after writing
+		var buf bytes.Buffer
+		if err := WriteMasterPage(&buf, &page); err != nil {
+			t.Fatal(err)
+		}
+		var newPage MasterPage
+		if err := ReadMasterPage(&buf, &newPage); err != nil {
+			t.Fatal(err)
+		}
+		if newPage != page {
+			t.Fatalf("mismatch master page: %+v, %+v", newPage, page)
+		}
+	})
 }
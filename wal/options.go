@@ -0,0 +1,320 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"math/bits"
+	"time"
+)
+
+const (
+	// DefaultMaxEntrySize is the default max data length of a log entry.
+	// It is reduced to the max data length of an entry that fits in the log on disk, e.g. with DefaultFileSize
+	DefaultMaxEntrySize = 64 << 20
+
+	// DefaultFileSize is the default size of the WAL file
+	DefaultFileSize = 64 << 20
+
+	// DefaultLogBufferSize is the default size of the in-memory log buffer
+	DefaultLogBufferSize = 1 << 20
+)
+
+// ErrInvalidOption is wrapped by the errors of Open and NewWAL when an option is not valid
+var ErrInvalidOption = errors.New("invalid option")
+
+type walOptions struct {
+	fileSize      int64
+	logBufferSize int64
+	maxEntrySize  int64
+	pageSize      int64
+
+	hasMaxEntrySize bool // set by WithMaxEntrySize, otherwise the default is used
+
+	flushDelay time.Duration
+	syncMode   SyncMode
+	checksum   ChecksumAlgorithm
+
+	failPolicy   FailPolicy
+	failCallback func(err error)
+
+	logger  *slog.Logger
+	metrics MetricsHooks
+}
+
+// FailPolicy decides what the WAL does after a write or fsync error.
+// With every policy the WAL first enters the failed state: the background writer stops,
+// all pending and future Write and WaitDurable calls return the error (also returned by Err)
+type FailPolicy int
+
+const (
+	// FailPolicyReturnError only returns the error to the callers
+	FailPolicyReturnError FailPolicy = iota
+
+	// FailPolicyPanic panics with the error
+	FailPolicyPanic
+
+	// FailPolicyCallback calls the callback set by WithFailCallback with the error
+	FailPolicyCallback
+)
+
+// SyncMode is the system call used to make the written pages durable
+type SyncMode int
+
+const (
+	// SyncModeDatasync uses fdatasync, the file metadata is not flushed
+	// because the size of the WAL file never changes after created
+	SyncModeDatasync SyncMode = iota
+
+	// SyncModeFsync uses fsync, for file systems on which fdatasync is not reliable
+	SyncModeFsync
+)
+
+// ChecksumAlgorithm is the checksum of the pages and the entries.
+// It is stored in the master page when the WAL file is created,
+// the master page itself always uses ChecksumCRC32IEEE
+type ChecksumAlgorithm uint8
+
+const (
+	// ChecksumCRC32IEEE is crc32 with the IEEE polynomial
+	ChecksumCRC32IEEE ChecksumAlgorithm = iota
+
+	// ChecksumCRC32C is crc32 with the Castagnoli polynomial, hardware accelerated on most CPUs
+	ChecksumCRC32C
+)
+
+var crc32CastagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+func (a ChecksumAlgorithm) isValid() bool {
+	return a == ChecksumCRC32IEEE || a == ChecksumCRC32C
+}
+
+func (a ChecksumAlgorithm) table() *crc32.Table {
+	if a == ChecksumCRC32C {
+		return crc32CastagnoliTable
+	}
+	return crc32.IEEETable
+}
+
+// MetricsHooks are called by the WAL to report its metrics, nil hooks are skipped.
+// The hooks are called outside the mutex lock and must not block
+type MetricsHooks struct {
+	// OnFlush is called by the background writer after the pages are written and synced to disk
+	OnFlush func(numPages int64, duration time.Duration)
+
+	// OnCheckpoint is called after the checkpoint lsn is persisted to the master page
+	OnCheckpoint func(lsn LSN, duration time.Duration)
+}
+
+// Option configures the WAL created by Open or NewWAL
+type Option func(opts *walOptions)
+
+func newWalOptions(options ...Option) walOptions {
+	opts := walOptions{
+		fileSize:      DefaultFileSize,
+		logBufferSize: DefaultLogBufferSize,
+		pageSize:      DefaultPageSize,
+		logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	for _, fn := range options {
+		fn(&opts)
+	}
+	return opts
+}
+
+// WithFileSize sets the size of a new WAL file, must be a multiple of the page size.
+// An existing WAL file must be opened with the same size
+func WithFileSize(size int64) Option {
+	return func(opts *walOptions) {
+		opts.fileSize = size
+	}
+}
+
+// WithLogBufferSize sets the size of the in-memory log buffer, must be a multiple of the page size
+// and at least two pages
+func WithLogBufferSize(size int64) Option {
+	return func(opts *walOptions) {
+		opts.logBufferSize = size
+	}
+}
+
+// WithMaxEntrySize sets the max data length of a log entry, writing a bigger entry returns ErrEntryTooLarge.
+// A size bigger than the max size of an entry that fits in the log on disk is not valid
+func WithMaxEntrySize(size int64) Option {
+	return func(opts *walOptions) {
+		opts.maxEntrySize = size
+		opts.hasMaxEntrySize = true
+	}
+}
+
+// WithPageSize sets the page size of a new WAL file, must be a power of two
+// in range [512, 64KB]. An existing WAL file must be opened with the same page size.
+// A page bigger than 512 bytes can be torn by a crash, it needs one more page on disk
+// and one more fsync when a flush rewrites a page that contains durable bytes
+func WithPageSize(pageSize int64) Option {
+	return func(opts *walOptions) {
+		opts.pageSize = pageSize
+	}
+}
+
+// WithFlushDelay makes the background writer wait for the delay after new bytes are written,
+// so more entries are made durable by the same fsync. Default is zero: flush immediately
+func WithFlushDelay(delay time.Duration) Option {
+	return func(opts *walOptions) {
+		opts.flushDelay = delay
+	}
+}
+
+// WithSyncMode sets the system call used to make the written pages durable, default is SyncModeDatasync
+func WithSyncMode(mode SyncMode) Option {
+	return func(opts *walOptions) {
+		opts.syncMode = mode
+	}
+}
+
+// WithChecksum sets the checksum algorithm of a new WAL file, default is ChecksumCRC32IEEE.
+// An existing WAL file must be opened with the same algorithm
+func WithChecksum(algorithm ChecksumAlgorithm) Option {
+	return func(opts *walOptions) {
+		opts.checksum = algorithm
+	}
+}
+
+// WithFailPolicy sets the policy after a write or fsync error, default is FailPolicyReturnError
+func WithFailPolicy(policy FailPolicy) Option {
+	return func(opts *walOptions) {
+		opts.failPolicy = policy
+	}
+}
+
+// WithFailCallback sets the policy to FailPolicyCallback with the callback.
+// The callback is called once, outside the mutex lock. After a write or fsync error, it is called
+// after the background writer is stopped, so it can call Shutdown.
+// Shutdown does not wait for the callback to return
+func WithFailCallback(fn func(err error)) Option {
+	return func(opts *walOptions) {
+		opts.failPolicy = FailPolicyCallback
+		opts.failCallback = fn
+	}
+}
+
+// WithLogger sets the logger for the recovery and the failures, default discards all logs
+func WithLogger(logger *slog.Logger) Option {
+	return func(opts *walOptions) {
+		opts.logger = logger
+	}
+}
+
+// WithMetricsHooks sets the hooks to report the metrics of the WAL
+func WithMetricsHooks(hooks MetricsHooks) Option {
+	return func(opts *walOptions) {
+		opts.metrics = hooks
+	}
+}
+
+// validate checks all the options, returns the page layout.
+// The default max entry size is reduced to the max size of an entry in the log on disk
+func (o *walOptions) validate() (PageLayout, error) {
+	layout, err := o.pageLayout()
+	if err != nil {
+		return PageLayout{}, err
+	}
+
+	pageSize := layout.PageSize()
+	if o.fileSize <= 0 || o.fileSize%pageSize != 0 {
+		return PageLayout{}, invalidOptionf(
+			"file size %d is not a positive multiple of the page size %d", o.fileSize, pageSize,
+		)
+	}
+	reservedNumPage := int64(masterNumPageOf(pageSize) + tailBackupNumPageOf(pageSize))
+	minNumPage := reservedNumPage + minLogNumPage
+	if o.fileSize/pageSize < minNumPage {
+		return PageLayout{}, invalidOptionf(
+			"file size %d is too small, needs at least %d pages for the master page and the log",
+			o.fileSize, minNumPage,
+		)
+	}
+
+	if o.logBufferSize <= 0 || o.logBufferSize%pageSize != 0 {
+		return PageLayout{}, invalidOptionf(
+			"log buffer size %d is not a positive multiple of the page size %d", o.logBufferSize, pageSize,
+		)
+	}
+	if o.logBufferSize/pageSize < minLogBufferNumPage {
+		return PageLayout{}, invalidOptionf(
+			"log buffer size %d is too small, needs at least %d pages", o.logBufferSize, minLogBufferNumPage,
+		)
+	}
+	maxEntrySize := maxEntrySizeOf(layout, PageNum(o.fileSize/pageSize-reservedNumPage))
+	if !o.hasMaxEntrySize {
+		o.maxEntrySize = min(DefaultMaxEntrySize, maxEntrySize)
+	}
+	if o.maxEntrySize <= 0 {
+		return PageLayout{}, invalidOptionf("max entry size %d is not positive", o.maxEntrySize)
+	}
+	if o.maxEntrySize > maxEntrySize {
+		return PageLayout{}, invalidOptionf(
+			"max entry size %d is bigger than %d, the max size of an entry in the log on disk",
+			o.maxEntrySize, maxEntrySize,
+		)
+	}
+	if o.flushDelay < 0 {
+		return PageLayout{}, invalidOptionf("flush delay %v is negative", o.flushDelay)
+	}
+
+	if o.syncMode != SyncModeDatasync && o.syncMode != SyncModeFsync {
+		return PageLayout{}, invalidOptionf("unknown sync mode %d", o.syncMode)
+	}
+	if !o.checksum.isValid() {
+		return PageLayout{}, invalidOptionf("unknown checksum algorithm %d", o.checksum)
+	}
+
+	switch o.failPolicy {
+	case FailPolicyReturnError, FailPolicyPanic:
+	case FailPolicyCallback:
+		if o.failCallback == nil {
+			return PageLayout{}, invalidOptionf("fail callback is nil")
+		}
+	default:
+		return PageLayout{}, invalidOptionf("unknown fail policy %d", o.failPolicy)
+	}
+
+	if o.logger == nil {
+		return PageLayout{}, invalidOptionf("logger is nil")
+	}
+	return layout, nil
+}
+
+const (
+	// minLogNumPage is the min number of pages on disk for the log
+	minLogNumPage = 2
+
+	// minLogBufferNumPage is the min number of pages of the log buffer.
+	// A new entry can skip the end of the current page to start at the next page,
+	// both pages must be in the log buffer
+	minLogBufferNumPage = 2
+)
+
+// maxEntrySizeOf returns the max data length of an entry that can be written to the log on disk
+// of ringNumPage pages. The entry can start at the next page of the checkpoint lsn,
+// then all pages after it are available if the checkpoint lsn is the end of the previous entry
+func maxEntrySizeOf(layout PageLayout, ringNumPage PageNum) int64 {
+	return int64(ringNumPage-1)*fragmentCapacity(layout.DataSizePerPage()) - entryChecksumSize
+}
+
+func (o walOptions) pageLayout() (PageLayout, error) {
+	if o.pageSize <= 0 || bits.OnesCount64(uint64(o.pageSize)) != 1 {
+		return PageLayout{}, invalidOptionf("page size %d is not a power of two", o.pageSize)
+	}
+	layout, err := NewPageLayout(uint8(bits.TrailingZeros64(uint64(o.pageSize))))
+	if err != nil {
+		return PageLayout{}, fmt.Errorf("%w: %w", ErrInvalidOption, err)
+	}
+	return layout, nil
+}
+
+func invalidOptionf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrInvalidOption, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,273 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/QuangTung97/go-wal/wal/filesys"
+)
+
+func TestOpen__Invalid_Options(t *testing.T) {
+	sizes := []Option{WithFileSize(testPageSize * 10), WithLogBufferSize(testPageSize * 4), WithPageSize(testPageSize)}
+
+	testCases := []struct {
+		name    string
+		options []Option
+		errMsg  string
+	}{
+		{
+			name:    "file size not multiple of page size",
+			options: []Option{WithFileSize(testPageSize*10 + 1)},
+			errMsg:  "file size 5121 is not a positive multiple of the page size 512",
+		},
+		{
+			name:    "zero file size",
+			options: []Option{WithFileSize(0)},
+			errMsg:  "file size 0 is not a positive multiple of the page size 512",
+		},
+		{
+			name:    "file too small",
+			options: []Option{WithFileSize(testPageSize * 3)},
+			errMsg:  "file size 1536 is too small, needs at least 4 pages for the master page and the log",
+		},
+		{
+			name:    "file too small with tail backup page",
+			options: []Option{WithPageSize(4096), WithFileSize(4096 * 3), WithLogBufferSize(4096)},
+			errMsg:  "file size 12288 is too small, needs at least 4 pages for the master page and the log",
+		},
+		{
+			name:    "zero log buffer size",
+			options: []Option{WithLogBufferSize(0)},
+			errMsg:  "log buffer size 0 is not a positive multiple of the page size 512",
+		},
+		{
+			name:    "log buffer of one page",
+			options: []Option{WithLogBufferSize(testPageSize)},
+			errMsg:  "log buffer size 512 is too small, needs at least 2 pages",
+		},
+		{
+			name:    "log buffer size not multiple of page size",
+			options: []Option{WithLogBufferSize(testPageSize + 100)},
+			errMsg:  "log buffer size 612 is not a positive multiple of the page size 512",
+		},
+		{
+			name:    "max entry size",
+			options: []Option{WithMaxEntrySize(0)},
+			errMsg:  "max entry size 0 is not positive",
+		},
+		{
+			name:    "max entry size bigger than the log on disk",
+			options: []Option{WithMaxEntrySize(3434)},
+			errMsg:  "max entry size 3434 is bigger than 3433, the max size of an entry in the log on disk",
+		},
+		{
+			name:    "flush delay",
+			options: []Option{WithFlushDelay(-time.Second)},
+			errMsg:  "flush delay -1s is negative",
+		},
+		{
+			name:    "sync mode",
+			options: []Option{WithSyncMode(SyncMode(5))},
+			errMsg:  "unknown sync mode 5",
+		},
+		{
+			name:    "checksum",
+			options: []Option{WithChecksum(ChecksumAlgorithm(7))},
+			errMsg:  "unknown checksum algorithm 7",
+		},
+		{
+			name:    "fail callback",
+			options: []Option{WithFailCallback(nil)},
+			errMsg:  "fail callback is nil",
+		},
+		{
+			name:    "fail policy",
+			options: []Option{WithFailPolicy(FailPolicy(9))},
+			errMsg:  "unknown fail policy 9",
+		},
+		{
+			name:    "logger",
+			options: []Option{WithLogger(nil)},
+			errMsg:  "logger is nil",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := filesys.NewMemFileSystem()
+			options := append(append([]Option{}, sizes...), tc.options...)
+
+			w, err := Open(fs, "/data/wal01", options...)
+			assert.Equal(t, (*WAL)(nil), w)
+			assert.Equal(t, true, errors.Is(err, ErrInvalidOption))
+			assert.Equal(t, "invalid option: "+tc.errMsg, err.Error())
+
+			// the file is not created
+			existed, err := fs.Exists("/data/wal01")
+			assert.Equal(t, nil, err)
+			assert.Equal(t, false, existed)
+		})
+	}
+}
+
+func TestNewWAL__Sizes_Override_Options(t *testing.T) {
+	fs := filesys.NewMemFileSystem()
+	w, err := NewWAL(fs, "/data/wal01", testPageSize*10, testPageSize*4, WithFileSize(testPageSize*3))
+	assert.Equal(t, nil, err)
+	defer w.Shutdown()
+
+	assert.Equal(t, PageNum(10), w.diskNumPage)
+	assert.Equal(t, PageNum(4), w.memNumPage)
+}
+
+func TestOpen__Checksum_CRC32C(t *testing.T) {
+	w := newWalTest(t, 10, 4, WithChecksum(ChecksumCRC32C))
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	lsn := w.addEntryAndNotify("input01")
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+
+	// the page on disk uses the castagnoli checksum
+	page := w.readDiskPage(t, 1)
+	page.crcTable = crc32.IEEETable
+	page.writeChecksum()
+	ieeeSum := page.data[checkSumOffset : checkSumOffset+4]
+	assert.NotEqual(t, ieeeSum, w.readRawDiskPage(t, 1)[checkSumOffset:checkSumOffset+4])
+
+	assert.Equal(t, ChecksumCRC32C, w.readMasterPage(t).Checksum)
+
+	w.reopen(t)
+	assert.Equal(t, []string{"input01"}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+	w.wal.Shutdown()
+
+	// reopen with a different checksum algorithm
+	_, err := NewWAL(w.fs, w.filename, testPageSize*10, testPageSize*4)
+	assert.Equal(t, true, errors.Is(err, ErrIncompatibleFile))
+	assert.Equal(t,
+		"incompatible wal file: checksum algorithm 1 of the WAL file is different from the configured checksum algorithm 0",
+		err.Error(),
+	)
+}
+
+type syncCountFileSystem struct {
+	filesys.FileSystem
+
+	mut           sync.Mutex
+	syncCount     int
+	datasyncCount int
+}
+
+type syncCountFile struct {
+	filesys.File
+	fs *syncCountFileSystem
+}
+
+func (fs *syncCountFileSystem) OpenFile(name string) (filesys.File, error) {
+	file, err := fs.FileSystem.OpenFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return &syncCountFile{File: file, fs: fs}, nil
+}
+
+func (f *syncCountFile) Sync() error {
+	f.fs.mut.Lock()
+	f.fs.syncCount++
+	f.fs.mut.Unlock()
+	return f.File.Sync()
+}
+
+func (f *syncCountFile) Datasync() error {
+	f.fs.mut.Lock()
+	f.fs.datasyncCount++
+	f.fs.mut.Unlock()
+	return f.File.Datasync()
+}
+
+func TestOpen__Sync_Mode(t *testing.T) {
+	t.Run("datasync", func(t *testing.T) {
+		fs := &syncCountFileSystem{FileSystem: filesys.NewMemFileSystem()}
+		w := newWalTestOnFS(t, fs, "/data/wal01", 10, 4)
+		assert.Equal(t, nil, w.wal.FinishRecover())
+
+		lsn := w.addEntryAndNotify("input01")
+		assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+		assert.Equal(t, nil, w.wal.Checkpoint(lsn))
+
+		assert.Equal(t, 0, fs.syncCount)
+		assert.Equal(t, 3, fs.datasyncCount)
+	})
+
+	t.Run("fsync", func(t *testing.T) {
+		fs := &syncCountFileSystem{FileSystem: filesys.NewMemFileSystem()}
+		w := newWalTestOnFS(t, fs, "/data/wal01", 10, 4, WithSyncMode(SyncModeFsync))
+		assert.Equal(t, nil, w.wal.FinishRecover())
+
+		lsn := w.addEntryAndNotify("input01")
+		assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+		assert.Equal(t, nil, w.wal.Checkpoint(lsn))
+
+		assert.Equal(t, 3, fs.syncCount)
+		assert.Equal(t, 0, fs.datasyncCount)
+	})
+}
+
+func TestOpen__Flush_Delay_And_Metrics(t *testing.T) {
+	var mut sync.Mutex
+	var flushedPages []int64
+	var checkpoints []LSN
+
+	w := newWalTest(t, 10, 4,
+		WithFlushDelay(50*time.Millisecond),
+		WithMetricsHooks(MetricsHooks{
+			OnFlush: func(numPages int64, duration time.Duration) {
+				mut.Lock()
+				flushedPages = append(flushedPages, numPages)
+				mut.Unlock()
+			},
+			OnCheckpoint: func(lsn LSN, duration time.Duration) {
+				checkpoints = append(checkpoints, lsn)
+			},
+		}),
+	)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	// both entries are flushed by the same fsync
+	w.addEntryAndNotify("input01")
+	lsn := w.addEntryAndNotify("input02")
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+
+	mut.Lock()
+	assert.Equal(t, []int64{1}, flushedPages)
+	mut.Unlock()
+
+	assert.Equal(t, nil, w.wal.Checkpoint(lsn))
+	assert.Equal(t, []LSN{lsn}, checkpoints)
+}
+
+func TestOpen__Logger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+
+	w := newWalTest(t, 10, 4, WithLogger(logger))
+	assert.Equal(t, nil, w.wal.FinishRecover())
+	assert.Equal(t,
+		"level=INFO msg=\"wal is recovered\" checkpoint_lsn=511 last_lsn=511 discarded_bytes=0 epoch=1\n",
+		buf.String(),
+	)
+}
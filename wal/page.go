@@ -2,7 +2,7 @@ package wal
 
 import (
 	"encoding/binary"
-	"errors"
+	"fmt"
 	"hash/crc32"
 	"io"
 )
@@ -62,14 +62,13 @@ const (
 )
 
 type Page struct {
-	data []byte // must have cap = len = 512
+	data     []byte       // must have cap = len = page size
+	crcTable *crc32.Table // table of the page checksum, crc32.IEEETable if nil
 }
 
-var pageWithZeros [PageSize]byte
-
 func InitPage(p *Page, epoch Epoch, num PageNum) {
 	// clear page with zeros
-	copy(p.data[:], pageWithZeros[:])
+	clear(p.data)
 
 	p.data[0] = uint8(FirstVersion)
 	binary.LittleEndian.PutUint32(p.data[pageEpochOffset:], epoch.val)
@@ -99,29 +98,49 @@ func (p *Page) GetLogData() []byte {
 }
 
 func (p *Page) Write(writer io.Writer) error {
-	crcSum := crc32.ChecksumIEEE(p.data[:])
-	binary.LittleEndian.PutUint32(p.data[checkSumOffset:], crcSum)
+	p.writeChecksum()
 	_, err := writer.Write(p.data[:])
 	p.clearChecksum()
 	return err
 }
 
+func (p *Page) writeChecksum() {
+	crcSum := crc32.Checksum(p.data[:], p.checksumTable())
+	binary.LittleEndian.PutUint32(p.data[checkSumOffset:], crcSum)
+}
+
+func (p *Page) checksumTable() *crc32.Table {
+	if p.crcTable == nil {
+		return crc32.IEEETable
+	}
+	return p.crcTable
+}
+
 func (p *Page) clearChecksum() {
 	// set crc sum to zero
 	var zeroSum [4]byte
 	copy(p.data[checkSumOffset:], zeroSum[:])
 }
 
+// ReadPage reads the page with size = len(p.data), then validates the checksum and the version
 func ReadPage(p *Page, reader io.Reader) error {
+	if len(p.data) < pageHeaderSize {
+		return fmt.Errorf("%w: %d", ErrInvalidPageSize, len(p.data))
+	}
+
 	if _, err := io.ReadFull(reader, p.data[:]); err != nil {
 		return err
 	}
 
 	crcSum := binary.LittleEndian.Uint32(p.data[checkSumOffset:])
 	p.clearChecksum()
-	computedSum := crc32.ChecksumIEEE(p.data[:])
+	computedSum := crc32.Checksum(p.data[:], p.checksumTable())
 	if computedSum != crcSum {
-		return errors.New("mismatch page checksum")
+		return &ChecksumError{Kind: DataKindPage}
+	}
+
+	if p.GetVersion() != FirstVersion {
+		return &VersionError{Kind: DataKindPage, Version: uint8(p.GetVersion())}
 	}
 
 	return nil
@@ -131,6 +150,7 @@ type pageIterator struct {
 	remainBytes []byte
 	entryType   EntryType
 	entryData   []byte
+	err         error
 }
 
 func (p *Page) newIterator() pageIterator {
@@ -139,13 +159,18 @@ func (p *Page) newIterator() pageIterator {
 	}
 }
 
+// next reads the next fragment, returns false at the end of page
+// or when the fragment is malformed (with err is set)
 func (i *pageIterator) next() bool {
-	if len(i.remainBytes) == 0 {
+	if len(i.remainBytes) == 0 || i.err != nil {
 		return false
 	}
 
 	var consumed int64
-	i.entryType, i.entryData, consumed = ReadLogEntry(i.remainBytes)
+	i.entryType, i.entryData, consumed, i.err = ReadLogEntry(i.remainBytes)
+	if i.err != nil {
+		return false
+	}
 	i.remainBytes = i.remainBytes[consumed:]
 
 	return true
@@ -3,6 +3,7 @@ package wal
 import (
 	"bytes"
 	"errors"
+	"io"
 	"testing"
 	"unsafe"
 
@@ -13,10 +14,6 @@ func TestPageVersion(t *testing.T) {
 	assert.Equal(t, PageVersion(1), FirstVersion)
 }
 
-func TestZeroPage(t *testing.T) {
-	assert.Equal(t, 512, len(pageWithZeros[:]))
-}
-
 func TestPageFlags(t *testing.T) {
 	t.Run("not full", func(t *testing.T) {
 		var flags PageFlags
@@ -65,7 +62,7 @@ func TestPageOffsets(t *testing.T) {
 }
 
 func newTestPage() *Page {
-	var data [PageSize]byte
+	var data [testPageSize]byte
 	return &Page{
 		data: data[:],
 	}
@@ -107,5 +104,105 @@ func TestReadWritePage(t *testing.T) {
 	// mismatch checksum
 	data[511] = 11
 	err = ReadPage(newPage, bytes.NewReader(data))
-	assert.Equal(t, errors.New("mismatch page checksum"), err)
+	assert.Equal(t, &ChecksumError{Kind: DataKindPage}, err)
+	assert.Equal(t, true, errors.Is(err, ErrChecksumMismatch))
+	assert.Equal(t, "mismatch page checksum", err.Error())
+}
+
+func TestReadPage__Malformed(t *testing.T) {
+	t.Run("invalid version", func(t *testing.T) {
+		page := newTestPage()
+		InitPage(page, NewEpoch(1), 3)
+		page.data[0] = 2
+
+		var buf bytes.Buffer
+		assert.Equal(t, nil, page.Write(&buf))
+
+		err := ReadPage(newTestPage(), &buf)
+		assert.Equal(t, true, errors.Is(err, ErrUnsupportedVersion))
+		assert.Equal(t, "unsupported page version: 2", err.Error())
+
+		var versionErr *VersionError
+		assert.Equal(t, true, errors.As(err, &versionErr))
+		assert.Equal(t, &VersionError{Kind: DataKindPage, Version: 2}, versionErr)
+	})
+
+	t.Run("page smaller than header", func(t *testing.T) {
+		page := &Page{data: make([]byte, pageHeaderSize-1)}
+		err := ReadPage(page, bytes.NewReader(make([]byte, testPageSize)))
+		assert.Equal(t, true, errors.Is(err, ErrInvalidPageSize))
+	})
+
+	t.Run("not enough data", func(t *testing.T) {
+		err := ReadPage(newTestPage(), bytes.NewReader(make([]byte, testPageSize-1)))
+		assert.Equal(t, io.ErrUnexpectedEOF, err)
+	})
+}
+
+func TestPageIterator__Malformed_Entry(t *testing.T) {
+	page := newTestPage()
+	InitPage(page, NewEpoch(1), 3)
+
+	input := NewSimpleByteReader([]byte("hello"))
+	n := WriteLogEntry(page.GetLogData(), EntryTypeNormal, input, input.Len())
+	page.GetLogData()[n] = byte(EntryTypeLast + 1)
+
+	it := page.newIterator()
+	assert.Equal(t, true, it.next())
+	assert.Equal(t, "hello", string(it.entryData))
+
+	assert.Equal(t, false, it.next())
+	assert.Equal(t, true, errors.Is(it.err, errInvalidLogEntryType))
+	assert.Equal(t, false, it.next())
+}
+
+func FuzzReadPage(f *testing.F) {
+	page := newTestPage()
+	InitPage(page, NewEpoch(21), 3)
+	input := NewSimpleByteReader([]byte("hello"))
+	WriteLogEntry(page.GetLogData(), EntryTypeNormal, input, input.Len())
+
+	var buf bytes.Buffer
+	if err := page.Write(&buf); err != nil {
+		f.Fatal(err)
+	}
+	f.Add(buf.Bytes())
+	f.Add(make([]byte, testPageSize))
+	f.Add([]byte{1, 2, 3})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		page := newTestPage()
+		if err := ReadPage(page, bytes.NewReader(data)); err != nil {
+			return
+		}
+
+		it := page.newIterator()
+		for it.next() {
+		}
+	})
+}
+
+func FuzzPageIterator(f *testing.F) {
+	page := newTestPage()
+	input := NewSimpleByteReader([]byte("hello"))
+	n := WriteLogEntry(page.GetLogData(), EntryTypeNormal, input, input.Len())
+	f.Add(page.GetLogData()[:n+3])
+	f.Add([]byte{byte(EntryTypeMiddle), 0xff, 0xff, 0x03})
+
+	f.Fuzz(func(t *testing.T, logData []byte) {
+		page := newTestPage()
+		copy(page.GetLogData(), logData)
+
+		it := page.newIterator()
+		total := 0
+		for it.next() {
+			total++
+			if total > len(page.GetLogData()) {
+				t.Fatal("iterator does not stop")
+			}
+		}
+		if len(it.remainBytes) > 0 && it.err == nil {
+			t.Fatal("iterator stops without error")
+		}
+	})
 }
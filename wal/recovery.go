@@ -0,0 +1,449 @@
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/QuangTung97/go-wal/wal/types"
+)
+
+// recoveryState is the state of scanning the log entries after the checkpoint lsn
+type recoveryState struct {
+	page       Page
+	pageNum    PageNum
+	pageLoaded bool
+
+	masterEpoch  Epoch // latest epoch in the master page, no page can have a newer epoch
+	prevEpoch    Epoch // epoch of the last loaded page
+	hasPrevEpoch bool
+
+	nextLsn LSN // lsn of the next byte to read
+	lastLsn LSN // lsn of the last byte of the last complete entry
+
+	entryData []byte
+	finished  bool
+	err       error
+
+	discardedBytes int64 // bytes on disk after the recovered log, computed by finishRecovery
+
+	tailBackupPageNum PageNum // the torn page read from the tail backup page, zero if none
+}
+
+func newRecoveryState(layout PageLayout, crcTable *crc32.Table, checkpointLsn LSN, masterEpoch Epoch) *recoveryState {
+	return &recoveryState{
+		page: Page{
+			data:     make([]byte, layout.PageSize()),
+			crcTable: crcTable,
+		},
+		masterEpoch: masterEpoch,
+		nextLsn:     checkpointLsn + 1,
+		lastLsn:     checkpointLsn,
+	}
+}
+
+// NextRecoverEntry reads the next complete entry from disk.
+// Returns false when reaching the end of the valid log
+func (w *WAL) NextRecoverEntry() bool {
+	r := w.recovery
+	if r.finished {
+		return false
+	}
+
+	ok, err := w.readNextRecoverEntry()
+	if err != nil || !ok {
+		r.finished = true
+		r.entryData = nil
+		r.err = err
+		return false
+	}
+	return true
+}
+
+func (w *WAL) readNextRecoverEntry() (bool, error) {
+	r := w.recovery
+
+	l := w.layout
+	lsn := r.nextLsn
+	for {
+		if l.WithinPage(lsn) < pageHeaderSize {
+			lsn = l.PageStart(l.ToPageNum(lsn)) + pageHeaderSize
+		}
+		if w.remainPageSize(lsn) <= maxLogEntryHeaderSize {
+			// not enough space for the entry header => move to next page
+			isEnd, err := w.isLogEndAtPage(l.ToPageNum(lsn))
+			if err != nil || isEnd {
+				return false, err
+			}
+			lsn = l.PageStart(l.ToPageNum(lsn) + 1)
+			continue
+		}
+		break
+	}
+
+	ok, err := w.loadRecoverPage(l.ToPageNum(lsn))
+	if err != nil || !ok {
+		return false, err
+	}
+
+	entryType, data, consumed, err := ReadLogEntry(r.page.data[l.WithinPage(lsn):])
+	if err != nil {
+		return false, fmt.Errorf("%w: page %d", err, r.pageNum)
+	}
+
+	switch entryType {
+	case EntryTypeNormal:
+		r.entryData = append(r.entryData[:0], data...)
+		lsn += LSN(consumed)
+
+	case EntryTypeFirst:
+		r.entryData = append(r.entryData[:0], data...)
+		lsn, ok, err = w.readRemainFragments()
+		if err != nil || !ok {
+			return false, err
+		}
+
+	case EntryTypeMiddle, EntryTypeLast:
+		// an entry always starts with a normal or a first fragment
+		return false, fmt.Errorf(
+			"%w: %d without the first fragment: page %d", errInvalidLogEntryType, entryType, r.pageNum,
+		)
+
+	default:
+		// zeros after the last entry of the page
+		return false, nil
+	}
+
+	data, err = validateEntryChecksum(r.entryData, w.checksumTable)
+	if err != nil {
+		return false, &ChecksumError{Kind: DataKindEntry, PageNum: r.pageNum, LSN: lsn - 1}
+	}
+	r.entryData = data
+
+	r.nextLsn = lsn
+	r.lastLsn = lsn - 1
+	w.entryEnds = append(w.entryEnds, r.lastLsn)
+	return true, nil
+}
+
+// readRemainFragments appends the middle and last fragments of a split entry
+// to the entry data. Each fragment is at the beginning of the next pages.
+// Returns the lsn right after the last fragment.
+// Returns false if the entry is incomplete
+func (w *WAL) readRemainFragments() (LSN, bool, error) {
+	r := w.recovery
+	for {
+		isEnd, err := w.isLogEndAtPage(r.pageNum)
+		if err != nil || isEnd {
+			return 0, false, err
+		}
+
+		ok, err := w.loadRecoverPage(r.pageNum + 1)
+		if err != nil || !ok {
+			return 0, false, err
+		}
+
+		entryType, data, consumed, err := ReadLogEntry(r.page.data[pageHeaderSize:])
+		if err != nil {
+			return 0, false, fmt.Errorf("%w: page %d", err, r.pageNum)
+		}
+
+		switch entryType {
+		case EntryTypeMiddle:
+			r.entryData = append(r.entryData, data...)
+
+		case EntryTypeLast:
+			r.entryData = append(r.entryData, data...)
+			pageStart := w.layout.PageStart(r.pageNum)
+			return pageStart + pageHeaderSize + LSN(consumed), true, nil
+
+		default:
+			return 0, false, nil
+		}
+	}
+}
+
+// loadRecoverPage reads the page from disk and validates it.
+// Returns false if the page is not a valid page of the log
+func (w *WAL) loadRecoverPage(num PageNum) (bool, error) {
+	r := w.recovery
+	if r.pageLoaded && r.pageNum == num {
+		return true, nil
+	}
+	r.pageLoaded = false
+
+	ok, err := w.readDiskPage(&r.page, num)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	// page from an older epoch is not a continuation of the log.
+	// Page from a newer epoch than the master page can not be written by the WAL
+	epoch := r.page.GetEpoch()
+	if r.hasPrevEpoch && epoch.Before(r.prevEpoch) {
+		return false, nil
+	}
+	if epoch.After(r.masterEpoch) {
+		return false, nil
+	}
+
+	r.prevEpoch = epoch
+	r.hasPrevEpoch = true
+	r.pageNum = num
+	r.pageLoaded = true
+	return true, nil
+}
+
+// isLogEndAtPage returns true if the log can not continue to the next page of the page num:
+// the page is not valid, or it is marked as NotFull or Truncated.
+// A NotFull page is the last page when it was written, the later writes that
+// continue to the next page always rewrite it without the flag.
+// So the next page after a NotFull page on disk is from a write that was not durable
+func (w *WAL) isLogEndAtPage(num PageNum) (bool, error) {
+	ok, err := w.loadRecoverPage(num)
+	if err != nil || !ok {
+		return true, err
+	}
+	return isLastPageOfLog(&w.recovery.page), nil
+}
+
+func isLastPageOfLog(page *Page) bool {
+	flags := page.GetFlags()
+	return flags.IsNotFull() || flags.IsTruncated()
+}
+
+// readDiskPage reads and validates checksum, version and page number of the page.
+// Returns false if the page is not a valid page.
+// A page left over from the previous lap of the ring has a different page number.
+// If the page is not valid, it can be torn by a crash while rewriting it => reads the tail backup page
+func (w *WAL) readDiskPage(page *Page, num PageNum) (bool, error) {
+	ok, err := w.readPageAt(page, num, w.diskPageOffset(num))
+	if err != nil || ok || w.tailBackupNumPage() == 0 {
+		return ok, err
+	}
+	return w.readTailBackup(page, num)
+}
+
+// readTailBackup reads the page from the tail backup page.
+// Only a page of the latest epoch in the master page is accepted: the tail backup page
+// of an older epoch can be older than the pages written after the recovery of that epoch
+func (w *WAL) readTailBackup(page *Page, num PageNum) (bool, error) {
+	ok, err := w.readPageAt(page, num, w.tailBackupOffset())
+	if err != nil || !ok {
+		return false, err
+	}
+	if page.GetEpoch().Compare(w.recovery.masterEpoch) != 0 {
+		return false, nil
+	}
+
+	w.recovery.tailBackupPageNum = num
+	return true, nil
+}
+
+func (w *WAL) readPageAt(page *Page, num PageNum, offset int64) (bool, error) {
+	reader := io.NewSectionReader(w.file, offset, w.layout.PageSize())
+	if err := ReadPage(page, reader); err != nil {
+		if errors.Is(err, ErrChecksumMismatch) || errors.Is(err, ErrUnsupportedVersion) {
+			return false, nil
+		}
+		return false, newIOError("read", err)
+	}
+
+	if page.GetPageNum() != num {
+		return false, nil
+	}
+	return true, nil
+}
+
+type EntryReader struct {
+	reader  ByteReader
+	lastLsn LSN
+}
+
+// Read copies the entry data to data.
+// hasNext = true if there are remaining bytes of the entry
+func (r *EntryReader) Read(data []byte) (n int, hasNext bool) {
+	chunk := r.reader.Read(int64(len(data)))
+	n = copy(data, chunk)
+	return n, r.reader.Len() > 0
+}
+
+// GetLastLSN returns the lsn of the last byte of the entry
+func (r *EntryReader) GetLastLSN() LSN {
+	return r.lastLsn
+}
+
+// GetRecoveryEntry returns the reader of the entry found by NextRecoverEntry
+func (w *WAL) GetRecoveryEntry() EntryReader {
+	return EntryReader{
+		reader:  NewSimpleByteReader(w.recovery.entryData),
+		lastLsn: w.recovery.lastLsn,
+	}
+}
+
+// finishRecovery consumes the remaining entries and setups the in memory state
+// to continue writing right after the last complete entry
+func (w *WAL) finishRecovery() error {
+	for w.NextRecoverEntry() {
+	}
+	if w.recovery.err != nil {
+		return w.recovery.err
+	}
+
+	l := w.layout
+	lastLsn := w.recovery.lastLsn
+
+	discarded, err := w.countDiscardedBytes(lastLsn)
+	if err != nil {
+		return err
+	}
+	w.recovery.discardedBytes = discarded
+
+	w.latestOffset = l.ToOffset(lastLsn)
+	w.copiedOffset = w.latestOffset
+	w.writtenLsn = lastLsn
+	w.durableLsn = lastLsn
+	w.durableWaiter.SetLSN(types.LSN(lastLsn))
+
+	if w.remainPageSize(lastLsn) == 1 {
+		// the last page is full => new entries are written to the next page
+		return nil
+	}
+
+	lastPage := &w.recovery.page
+	ok, err := w.readDiskPage(lastPage, l.ToPageNum(lastLsn))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: can not read the last page %d of the log", ErrCorruptPage, l.ToPageNum(lastLsn))
+	}
+
+	// keep the data of the last page, but with the new epoch
+	within := l.WithinPage(lastLsn)
+	page := w.getInMemPage(l.ToPageNum(lastLsn))
+	InitPage(&page, w.latestEpoch, l.ToPageNum(lastLsn))
+	copy(page.data[pageHeaderSize:within+1], lastPage.data[pageHeaderSize:within+1])
+
+	return nil
+}
+
+// countDiscardedBytes returns the number of bytes after lastLsn, until the last non-zero byte,
+// of the pages that look like a continuation of the log on disk, e.g. an incomplete entry.
+// The pages are scanned with the same rules of the recovery: valid pages with increasing epochs,
+// stop after a NotFull or Truncated page
+func (w *WAL) countDiscardedBytes(lastLsn LSN) (int64, error) {
+	l := w.layout
+	page := &w.recovery.page
+	w.recovery.pageLoaded = false
+
+	num := l.ToPageNum(lastLsn + 1)
+	from := max(l.WithinPage(lastLsn+1), pageHeaderSize)
+
+	var discarded int64
+	var zeros int64 // zero bytes after the last counted non-zero byte
+	var prevEpoch Epoch
+	for i := PageNum(0); i < w.diskRingNumPage(); i++ {
+		ok, err := w.readDiskPage(page, num+i)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			break
+		}
+
+		epoch := page.GetEpoch()
+		if i > 0 && epoch.Before(prevEpoch) {
+			break
+		}
+		if epoch.After(w.recovery.masterEpoch) {
+			break
+		}
+		prevEpoch = epoch
+
+		data := page.data[from:]
+		from = pageHeaderSize
+
+		last := bytes.LastIndexFunc(data, func(r rune) bool { return r != 0 })
+		if last < 0 {
+			zeros += int64(len(data))
+		} else {
+			discarded += zeros + int64(last) + 1
+			zeros = int64(len(data) - last - 1)
+		}
+
+		if isLastPageOfLog(page) {
+			break
+		}
+	}
+	return discarded, nil
+}
+
+// GetDiscardedBytes returns the number of bytes after the end of the recovered log
+// that are discarded by FinishRecover, e.g. the fragments of an incomplete entry
+func (w *WAL) GetDiscardedBytes() int64 {
+	return w.recovery.discardedBytes
+}
+
+// truncateLogTail durably rewrites the page that the next entry will be written to,
+// with the data of the recovered log and the Truncated flag.
+// So the discarded bytes after the log are never read as a continuation of the new entries
+func (w *WAL) truncateLogTail() error {
+	if w.recovery.discardedBytes == 0 {
+		return nil
+	}
+
+	l := w.layout
+	lastLsn := w.recovery.lastLsn
+	num := l.ToPageNum(lastLsn + 1)
+
+	page := &w.recovery.page
+	rewrite := num == l.ToPageNum(lastLsn)
+	if rewrite {
+		copy(page.data, w.getInMemPage(num).data)
+	} else {
+		InitPage(page, w.latestEpoch, num)
+	}
+	page.GetFlags().SetNotFull(true)
+	page.GetFlags().SetTruncated(true)
+	page.writeChecksum()
+
+	// the page contains the recovered log => it must not be torn
+	if rewrite && w.tailBackupNumPage() > 0 {
+		if err := w.writeTailBackup(page.data); err != nil {
+			return err
+		}
+	}
+
+	if _, err := w.file.WriteAt(page.data, w.diskPageOffset(num)); err != nil {
+		return newIOError("write", err)
+	}
+	return newIOError("sync", w.syncFile())
+}
+
+// restoreTailBackup rewrites the torn page that the recovery has read from the tail backup page.
+// It must be durable before persisting the new epoch, which makes the tail backup page invalid
+func (w *WAL) restoreTailBackup() error {
+	num := w.recovery.tailBackupPageNum
+	if num == 0 {
+		return nil
+	}
+
+	page := &w.recovery.page
+	w.recovery.pageLoaded = false
+	ok, err := w.readPageAt(page, num, w.tailBackupOffset())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: can not read the tail backup page of page %d", ErrCorruptPage, num)
+	}
+	page.writeChecksum()
+
+	if _, err := w.file.WriteAt(page.data, w.diskPageOffset(num)); err != nil {
+		return newIOError("write", err)
+	}
+	return newIOError("sync", w.syncFile())
+}
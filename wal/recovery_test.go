@@ -0,0 +1,704 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/QuangTung97/go-wal/wal/filesys"
+)
+
+func (w *walTest) readAllRecoverEntries() []string {
+	var result []string
+	for w.wal.NextRecoverEntry() {
+		reader := w.wal.GetRecoveryEntry()
+
+		var entry []byte
+		buf := make([]byte, 7)
+		for {
+			n, hasNext := reader.Read(buf)
+			entry = append(entry, buf[:n]...)
+			if !hasNext {
+				break
+			}
+		}
+		result = append(result, string(entry))
+	}
+	return result
+}
+
+func (w *walTest) corruptDiskPage(t *testing.T, num PageNum) {
+	file, err := w.fs.OpenFile(w.filename)
+	require.Equal(t, nil, err)
+	defer func() { _ = file.Close() }()
+
+	_, err = file.WriteAt([]byte("corrupted"), w.wal.diskPageOffset(num)+100)
+	require.Equal(t, nil, err)
+}
+
+func TestWAL__Recover__Empty_Log(t *testing.T) {
+	w := newWalTest(t, 10, 4)
+	assert.Equal(t, []string(nil), w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	w.reopen(t)
+	assert.Equal(t, []string(nil), w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	assert.Equal(t, LSN(testPageSize-1), w.wal.writtenLsn)
+	assert.Equal(t, LogDataOffset(testDataSizePerPage-1), w.wal.latestOffset)
+}
+
+func TestWAL__Recover__Entries_Then_Continue_Writing(t *testing.T) {
+	w := newWalTest(t, 10, 4)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	bigEntry := strings.Repeat("A", 300) + strings.Repeat("B", 700)
+
+	w.addEntryAndNotify("input01")
+	w.addEntryAndNotify(bigEntry)
+	w.addEntryAndNotify("input03")
+
+	w.reopen(t)
+
+	assert.Equal(t, true, w.wal.NextRecoverEntry())
+	entry := w.wal.GetRecoveryEntry()
+	assert.Equal(t, LSN(testPageSize+pageHeaderSize+13-1), entry.GetLastLSN())
+
+	assert.Equal(t, []string{bigEntry, "input03"}, w.readAllRecoverEntries())
+	assert.Equal(t, false, w.wal.NextRecoverEntry())
+
+	assert.Equal(t, nil, w.wal.FinishRecover())
+	w.addEntryAndNotify("input04")
+
+	w.reopen(t)
+	assert.Equal(t, []string{"input01", bigEntry, "input03", "input04"}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+}
+
+func TestWAL__Recover__Finish_Without_Reading_Entries(t *testing.T) {
+	w := newWalTest(t, 10, 4)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	w.addEntryAndNotify("input01")
+	w.addEntryAndNotify("input02")
+
+	w.reopen(t)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+	w.addEntryAndNotify("input03")
+
+	w.reopen(t)
+	assert.Equal(t, []string{"input01", "input02", "input03"}, w.readAllRecoverEntries())
+}
+
+func TestWAL__Recover__Incomplete_Split_Entry_Is_Discarded(t *testing.T) {
+	w := newWalTest(t, 10, 4)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	w.addEntryAndNotify("input01")
+	w.addEntryAndNotify(strings.Repeat("A", 1000)) // from page 1 to page 3
+	w.wal.Shutdown()
+
+	w.corruptDiskPage(t, 3)
+
+	w.openWAL(t)
+	assert.Equal(t, []string{"input01"}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	w.addEntryAndNotify("input03")
+
+	w.reopen(t)
+	assert.Equal(t, []string{"input01", "input03"}, w.readAllRecoverEntries())
+}
+
+func TestWAL__Recover__Entries_At_End_Of_Page(t *testing.T) {
+	w := newWalTest(t, 10, 4)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	// fill the second page, except the last bytes that can not contain an entry header
+	first := strings.Repeat("A", 200)
+	second := strings.Repeat("B", testDataSizePerPage-maxLogEntryHeaderSize-200-2*(3+entryChecksumSize))
+	w.addEntryAndNotify(first)
+	w.addEntryAndNotify(second)
+
+	w.reopen(t)
+	assert.Equal(t, []string{first, second}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+	w.addEntryAndNotify("input03")
+
+	w.reopen(t)
+	assert.Equal(t, []string{first, second, "input03"}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	page3 := w.readDiskPage(t, 2)
+	it := page3.newIterator()
+	assert.Equal(t, true, it.next())
+	assert.Equal(t, withChecksum("input03"), string(it.entryData))
+}
+
+func TestWAL__Recover__Entry_Bigger_Than_64KB(t *testing.T) {
+	w := newWalTest(t, 300, 20)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	bigEntry := strings.Repeat("ABCDEFGHIJ", 10000)
+	w.addEntryAndNotify("input01")
+	w.addEntryAndNotify(bigEntry)
+	w.addEntryAndNotify("input03")
+
+	w.reopen(t)
+	assert.Equal(t, []string{"input01", bigEntry, "input03"}, w.readAllRecoverEntries())
+}
+
+// rewriteDiskPage modifies the data of the page on disk, the page checksum is still valid
+func (w *walTest) rewriteDiskPage(t *testing.T, num PageNum, fn func(page *Page)) {
+	page := w.readDiskPage(t, num)
+	fn(page)
+
+	file, err := w.fs.OpenFile(w.filename)
+	require.Equal(t, nil, err)
+	defer func() { _ = file.Close() }()
+
+	err = page.Write(io.NewOffsetWriter(file, w.wal.diskPageOffset(num)))
+	require.Equal(t, nil, err)
+}
+
+func TestWAL__Recover__Mismatch_Entry_Checksum(t *testing.T) {
+	w := newWalTest(t, 10, 4)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	w.addEntryAndNotify("input01")
+	lsn := w.addEntryAndNotify(strings.Repeat("A", 1000)) // from page 1 to page 3
+	w.addEntryAndNotify("input03")
+	w.wal.Shutdown()
+
+	// corrupt the middle fragment
+	w.rewriteDiskPage(t, 2, func(page *Page) {
+		page.GetLogData()[100] = 'B'
+	})
+
+	w.openWAL(t)
+	assert.Equal(t, []string{"input01"}, w.readAllRecoverEntries())
+
+	err := w.wal.FinishRecover()
+	assert.Equal(t, true, errors.Is(err, ErrChecksumMismatch))
+
+	var checksumErr *ChecksumError
+	assert.Equal(t, true, errors.As(err, &checksumErr))
+	assert.Equal(t, &ChecksumError{Kind: DataKindEntry, PageNum: 3, LSN: lsn}, checksumErr)
+	assert.Equal(t, fmt.Sprintf("mismatch entry checksum: entry at lsn %d: page 3", lsn), err.Error())
+}
+
+func newFaultWalTest(t *testing.T, seed int64, pageOnDisk int64, pageOnMem int64, options ...Option) *walTest {
+	fs := filesys.NewFaultFileSystem(filesys.NewMemFileSystem(), testPageSize, seed)
+	return newWalTestOnFS(t, fs, "/data/wal01", pageOnDisk, pageOnMem, options...)
+}
+
+// crash opens the WAL on the state of the file system after a power loss
+func (w *walTest) crash(t *testing.T, mode filesys.CrashMode) {
+	w.fs = w.fs.(*filesys.FaultFileSystem).Crash(mode)
+	w.wal.Shutdown()
+	w.openWAL(t)
+}
+
+func TestWAL__Recover__After_Crash(t *testing.T) {
+	modes := []filesys.CrashMode{
+		filesys.CrashDropUnsynced,
+		filesys.CrashReorderUnsynced,
+		filesys.CrashTornWrites,
+	}
+
+	for _, mode := range modes {
+		for seed := int64(0); seed < 20; seed++ {
+			w := newFaultWalTest(t, seed, 20, 8)
+			assert.Equal(t, nil, w.wal.FinishRecover())
+
+			var entries []string
+			numDurable := 0
+			for i := 0; i < 10; i++ {
+				entry := strings.Repeat(string(rune('A'+i)), 100*i+1)
+				entries = append(entries, entry)
+				lsn := w.addEntryAndNotify(entry)
+
+				if i%3 == 0 {
+					assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+					numDurable = i + 1
+				}
+			}
+
+			w.crash(t, mode)
+			recovered := w.readAllRecoverEntries()
+			assert.Equal(t, nil, w.wal.FinishRecover())
+
+			// durable entries <= recovered entries <= written entries
+			assert.GreaterOrEqual(t, len(recovered), numDurable)
+			assert.Equal(t, entries[:len(recovered)], recovered)
+		}
+	}
+}
+
+func TestWAL__Recover__Crash_After_Write_Error(t *testing.T) {
+	w := newFaultWalTest(t, 1, 20, 8)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	lsn := w.addEntryAndNotify("input01")
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+
+	writeErr := errors.New("write error")
+	w.fs.(*filesys.FaultFileSystem).InjectError(filesys.FaultOpWrite, 0, writeErr)
+
+	lsn = w.addEntryAndNotify("input02")
+	assert.Equal(t, &IOError{Op: "write", Err: writeErr}, w.wal.WaitDurable(lsn))
+	assert.Equal(t, true, errors.Is(w.wal.Err(), writeErr))
+
+	w.crash(t, filesys.CrashTornWrites)
+	assert.Equal(t, []string{"input01"}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+}
+
+func TestWAL__Recover__Torn_Rewrite_Of_Durable_Page(t *testing.T) {
+	// the page size is bigger than the sector size of the file system
+	for skip := 0; skip < 2; skip++ {
+		for seed := int64(0); seed < 50; seed++ {
+			w := newFaultWalTest(t, seed, 10, 4, WithPageSize(4096))
+			assert.Equal(t, nil, w.wal.FinishRecover())
+
+			lsn := w.addEntryAndNotify("input01")
+			assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+
+			// the page of input01 is rewritten with input02, then the fsync fails
+			syncErr := errors.New("sync error")
+			w.fs.(*filesys.FaultFileSystem).InjectError(filesys.FaultOpSync, skip, syncErr)
+			lsn = w.addEntryAndNotify(strings.Repeat("B", 3000))
+			assert.Equal(t, &IOError{Op: "sync", Err: syncErr}, w.wal.WaitDurable(lsn))
+
+			w.crash(t, filesys.CrashTornWrites)
+			recovered := w.readAllRecoverEntries()
+			assert.Equal(t, nil, w.wal.FinishRecover())
+
+			assert.GreaterOrEqual(t, len(recovered), 1)
+			assert.Equal(t, []string{"input01", strings.Repeat("B", 3000)}[:len(recovered)], recovered)
+
+			// the torn page is restored from the tail backup page before the new epoch
+			w.reopen(t)
+			assert.Equal(t, recovered, w.readAllRecoverEntries())
+			assert.Equal(t, nil, w.wal.FinishRecover())
+		}
+	}
+}
+
+func (w *walTest) checkRecovering(t *testing.T) {
+	w.wal.Lock()
+	_, err := w.wal.Write(NewSimpleByteReader([]byte("input02")))
+	assert.Equal(t, ErrRecovering, err)
+	_, err = w.wal.TryWrite(NewSimpleByteReader([]byte("input02")))
+	assert.Equal(t, ErrRecovering, err)
+	w.wal.Unlock()
+
+	_, err = w.wal.NewEntry(10)
+	assert.Equal(t, ErrRecovering, err)
+	assert.Equal(t, ErrRecovering, w.wal.WaitDurable(w.wal.checkpointLsn+1))
+	assert.Equal(t, ErrRecovering, w.wal.Checkpoint(w.wal.checkpointLsn+1))
+}
+
+func TestWAL__Recover__Write_Before_Finish_Recover(t *testing.T) {
+	w := newWalTest(t, 10, 4)
+	w.checkRecovering(t)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	lsn := w.addEntryAndNotify("input01")
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+
+	w.reopen(t)
+	w.checkRecovering(t)
+	assert.Equal(t, []string{"input01"}, w.readAllRecoverEntries())
+	w.checkRecovering(t)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	lsn = w.addEntryAndNotify("input02")
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+
+	w.reopen(t)
+	assert.Equal(t, []string{"input01", "input02"}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+}
+
+func TestWAL__Recover__Write_After_Finish_Recover_Error(t *testing.T) {
+	w := newFaultWalTest(t, 1, 10, 4)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+	lsn := w.addEntryAndNotify("input01")
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+	w.reopen(t)
+
+	syncErr := errors.New("sync error")
+	w.fs.(*filesys.FaultFileSystem).InjectError(filesys.FaultOpSync, 0, syncErr)
+	assert.Equal(t, &IOError{Op: "sync", Err: syncErr}, w.wal.FinishRecover())
+
+	// the WAL is still recovering
+	w.checkRecovering(t)
+
+	w.reopen(t)
+	assert.Equal(t, []string{"input01"}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+}
+
+func TestWAL__Recover__Last_Page_Corrupted_Before_Finish_Recover(t *testing.T) {
+	w := newWalTest(t, 10, 4)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+	lsn := w.addEntryAndNotify("input01")
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+
+	w.reopen(t)
+	assert.Equal(t, []string{"input01"}, w.readAllRecoverEntries())
+
+	w.corruptDiskPage(t, 1)
+	err := w.wal.FinishRecover()
+	assert.Equal(t, true, errors.Is(err, ErrCorruptPage))
+	assert.Equal(t, "corrupt page: can not read the last page 1 of the log", err.Error())
+}
+
+func TestWAL__Recover__Tail_Backup_Corrupted_Before_Finish_Recover(t *testing.T) {
+	w := newWalTest(t, 10, 4, WithPageSize(4096))
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	// page 1 is rewritten with the second entry => it is in the tail backup page
+	lsn := w.addEntryAndNotify("input01")
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+	bigEntry := strings.Repeat("B", 5000)
+	lsn = w.addEntryAndNotify(bigEntry)
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+	w.wal.Shutdown()
+
+	// page 1 is read from the tail backup page
+	w.corruptDiskPage(t, 1)
+	w.openWAL(t)
+	assert.Equal(t, []string{"input01", bigEntry}, w.readAllRecoverEntries())
+
+	file, err := w.fs.OpenFile(w.filename)
+	require.Equal(t, nil, err)
+	_, err = file.WriteAt([]byte("corrupted"), w.wal.tailBackupOffset()+100)
+	require.Equal(t, nil, err)
+	require.Equal(t, nil, file.Close())
+
+	err = w.wal.FinishRecover()
+	assert.Equal(t, true, errors.Is(err, ErrCorruptPage))
+	assert.Equal(t, "corrupt page: can not read the tail backup page of page 1", err.Error())
+}
+
+func TestWAL__Recover__Persist_New_Epoch(t *testing.T) {
+	w := newWalTest(t, 10, 4)
+	assert.Equal(t, NewEpoch(0), w.readMasterPage(t).LatestEpoch)
+
+	assert.Equal(t, nil, w.wal.FinishRecover())
+	assert.Equal(t, NewEpoch(1), w.readMasterPage(t).LatestEpoch)
+
+	lsn := w.addEntryAndNotify("input01")
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+	assert.Equal(t, NewEpoch(1), w.readDiskPage(t, 1).GetEpoch())
+
+	w.reopen(t)
+	assert.Equal(t, NewEpoch(1), w.wal.latestEpoch)
+	assert.Equal(t, []string{"input01"}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+	assert.Equal(t, NewEpoch(2), w.readMasterPage(t).LatestEpoch)
+
+	// the last page is rewritten with the new epoch
+	lsn = w.addEntryAndNotify("input02")
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+	assert.Equal(t, NewEpoch(2), w.readDiskPage(t, 1).GetEpoch())
+
+	// checkpoint keeps the latest epoch
+	assert.Equal(t, nil, w.wal.Checkpoint(lsn))
+	assert.Equal(t, NewEpoch(2), w.readMasterPage(t).LatestEpoch)
+}
+
+func TestWAL__Recover__Reject_Page_Of_Older_Epoch_After_Log_Tail(t *testing.T) {
+	w := newWalTest(t, 10, 4)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	// each entry fills a whole page
+	w.addEntryAndNotify(newFullPageEntry('A'))
+	w.addEntryAndNotify(newFullPageEntry('B'))
+	lsn := w.addEntryAndNotify(newFullPageEntry('C'))
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+	w.wal.Shutdown()
+
+	// the log ends at page 1
+	w.corruptDiskPage(t, 2)
+	w.openWAL(t)
+	assert.Equal(t, []string{newFullPageEntry('A')}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	// page 2 is written again, page 3 is from the previous epoch
+	lsn = w.addEntryAndNotify(newFullPageEntry('X'))
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+	assert.Equal(t, NewEpoch(2), w.readDiskPage(t, 2).GetEpoch())
+	assert.Equal(t, NewEpoch(1), w.readDiskPage(t, 3).GetEpoch())
+
+	w.reopen(t)
+	assert.Equal(t, []string{
+		newFullPageEntry('A'),
+		newFullPageEntry('X'),
+	}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+}
+
+func TestWAL__Recover__Write_Epoch_Error(t *testing.T) {
+	w := newFaultWalTest(t, 1, 10, 4)
+
+	syncErr := errors.New("sync error")
+	w.fs.(*filesys.FaultFileSystem).InjectError(filesys.FaultOpSync, 0, syncErr)
+	assert.Equal(t, &IOError{Op: "sync", Err: syncErr}, w.wal.FinishRecover())
+
+	// the epoch is not persisted
+	w.crash(t, filesys.CrashDropUnsynced)
+	assert.Equal(t, NewEpoch(0), w.wal.latestEpoch)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+	assert.Equal(t, NewEpoch(1), w.readMasterPage(t).LatestEpoch)
+}
+
+func (w *walTest) writeMasterEpoch(t *testing.T, epoch Epoch) {
+	file, err := w.fs.OpenFile(w.filename)
+	require.Equal(t, nil, err)
+	defer func() { _ = file.Close() }()
+
+	masterPage := w.readMasterPage(t)
+	masterPage.LatestEpoch = epoch
+	masterPage.Sequence++
+	err = WriteMasterPageSlot(file, &masterPage)
+	require.Equal(t, nil, err)
+}
+
+func TestWAL__Recover__Epoch_Wraps_Around(t *testing.T) {
+	w := newWalTest(t, 10, 4)
+	w.wal.Shutdown()
+	w.writeMasterEpoch(t, NewEpoch(math.MaxUint32-1))
+
+	var entries []string
+	for i := 0; i < 3; i++ {
+		w.openWAL(t)
+		assert.Equal(t, entries, w.readAllRecoverEntries())
+		assert.Equal(t, nil, w.wal.FinishRecover())
+
+		// each entry fills a whole page
+		entry := newFullPageEntry(byte('A' + i))
+		entries = append(entries, entry)
+		lsn := w.addEntryAndNotify(entry)
+		assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+		w.wal.Shutdown()
+	}
+
+	assert.Equal(t, NewEpoch(math.MaxUint32), w.readDiskPage(t, 1).GetEpoch())
+	assert.Equal(t, NewEpoch(0), w.readDiskPage(t, 2).GetEpoch())
+	assert.Equal(t, NewEpoch(1), w.readDiskPage(t, 3).GetEpoch())
+
+	w.openWAL(t)
+	assert.Equal(t, entries, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+	assert.Equal(t, NewEpoch(2), w.readMasterPage(t).LatestEpoch)
+}
+
+func TestWAL__Recover__Page_Newer_Than_Master_Epoch_Is_End_Of_Log(t *testing.T) {
+	w := newWalTest(t, 10, 4)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	w.addEntryAndNotify(newFullPageEntry('A'))
+	lsn := w.addEntryAndNotify(newFullPageEntry('B'))
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+	w.wal.Shutdown()
+
+	w.rewriteDiskPage(t, 2, func(page *Page) {
+		binary.LittleEndian.PutUint32(page.data[pageEpochOffset:], 2)
+	})
+
+	w.openWAL(t)
+	assert.Equal(t, []string{newFullPageEntry('A')}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+}
+
+func (w *walTest) readRawDiskPage(t *testing.T, num PageNum) []byte {
+	data := w.readFile(t)
+	offset := w.wal.diskPageOffset(num)
+	return data[offset : offset+testPageSize]
+}
+
+func (w *walTest) writeRawDiskPage(t *testing.T, num PageNum, data []byte) {
+	file, err := w.fs.OpenFile(w.filename)
+	require.Equal(t, nil, err)
+	defer func() { _ = file.Close() }()
+
+	_, err = file.WriteAt(data, w.wal.diskPageOffset(num))
+	require.Equal(t, nil, err)
+}
+
+func TestWAL__Recover__Stop_After_Not_Full_Page(t *testing.T) {
+	w := newWalTest(t, 10, 4)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	// the entry ends at 5 bytes before the end of page 1
+	entry1 := strings.Repeat("A", 482)
+	lsn := w.addEntryAndNotify(entry1)
+	assert.Equal(t, LSN(2*testPageSize-5-1), lsn)
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+
+	notFullPage := w.readRawDiskPage(t, 1)
+	assert.Equal(t, true, w.readDiskPage(t, 1).GetFlags().IsNotFull())
+
+	// page 1 is rewritten as a full page
+	lsn = w.addEntryAndNotify("input02")
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+	assert.Equal(t, false, w.readDiskPage(t, 1).GetFlags().IsNotFull())
+	assert.Equal(t, true, w.readDiskPage(t, 2).GetFlags().IsNotFull())
+
+	// the rewrite of page 1 is lost
+	w.wal.Shutdown()
+	w.writeRawDiskPage(t, 1, notFullPage)
+
+	w.openWAL(t)
+	assert.Equal(t, []string{entry1}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+	assert.Equal(t, int64(0), w.wal.GetDiscardedBytes())
+
+	w.addEntryAndNotify("input03")
+
+	w.reopen(t)
+	assert.Equal(t, []string{entry1, "input03"}, w.readAllRecoverEntries())
+}
+
+func TestWAL__Recover__Truncate_Incomplete_Entry(t *testing.T) {
+	w := newWalTest(t, 10, 4)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	w.addEntryAndNotify("input01")
+	w.addEntryAndNotify(strings.Repeat("A", 1000)) // from page 1 to page 3
+	w.wal.Shutdown()
+
+	w.corruptDiskPage(t, 3)
+
+	w.openWAL(t)
+	assert.Equal(t, []string{"input01"}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	// fragments in page 1 and page 2
+	assert.Equal(t, int64(testDataSizePerPage-13+testDataSizePerPage), w.wal.GetDiscardedBytes())
+
+	page := w.readDiskPage(t, 1)
+	assert.Equal(t, true, page.GetFlags().IsTruncated())
+	assert.Equal(t, true, page.GetFlags().IsNotFull())
+	assert.Equal(t, NewEpoch(2), page.GetEpoch())
+	assert.Equal(t, withChecksum("input01"), string(page.GetLogData()[2:2+7+entryChecksumSize]))
+	assert.Equal(t, make([]byte, testDataSizePerPage-13), page.GetLogData()[13:])
+
+	w.reopen(t)
+	assert.Equal(t, []string{"input01"}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+	assert.Equal(t, int64(0), w.wal.GetDiscardedBytes())
+}
+
+func TestWAL__Recover__Truncate__New_Fragments_Never_Follow_Old_Incomplete_Entry(t *testing.T) {
+	w := newWalTest(t, 10, 4)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	w.addEntryAndNotify("input01")
+	w.addEntryAndNotify(strings.Repeat("A", 1000)) // from page 1 to page 3
+	w.wal.Shutdown()
+
+	w.corruptDiskPage(t, 3)
+
+	w.openWAL(t)
+	assert.Equal(t, []string{"input01"}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+	truncatedPage := w.readRawDiskPage(t, 1)
+
+	// new entry from page 1 to page 2
+	lsn := w.addEntryAndNotify(strings.Repeat("B", 600))
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+
+	// the rewrite of page 1 is lost, page 2 is persisted
+	w.wal.Shutdown()
+	w.writeRawDiskPage(t, 1, truncatedPage)
+
+	w.openWAL(t)
+	assert.Equal(t, []string{"input01"}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+}
+
+func TestWAL__Recover__Truncate_At_Page_End(t *testing.T) {
+	w := newWalTest(t, 10, 4)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	w.addEntryAndNotify(newFullPageEntry('A'))
+	w.addEntryAndNotify(strings.Repeat("B", 600)) // from page 2 to page 3
+	w.wal.Shutdown()
+
+	w.corruptDiskPage(t, 3)
+
+	w.openWAL(t)
+	assert.Equal(t, []string{newFullPageEntry('A')}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+	assert.Equal(t, int64(testDataSizePerPage), w.wal.GetDiscardedBytes())
+
+	// page 2 is truncated to an empty page
+	page := w.readDiskPage(t, 2)
+	assert.Equal(t, true, page.GetFlags().IsTruncated())
+	assert.Equal(t, make([]byte, testDataSizePerPage), page.GetLogData())
+
+	w.addEntryAndNotify("input02")
+
+	w.reopen(t)
+	assert.Equal(t, []string{newFullPageEntry('A'), "input02"}, w.readAllRecoverEntries())
+}
+
+func TestWAL__Recover__Malformed_Entry_Length(t *testing.T) {
+	w := newWalTest(t, 10, 4)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	w.addEntryAndNotify("input01")
+	w.addEntryAndNotify("input02")
+	w.wal.Shutdown()
+
+	// the page checksum is valid, but the length of the second entry exceeds the page
+	w.rewriteDiskPage(t, 1, func(page *Page) {
+		data := page.GetLogData()[13:]
+		binary.PutUvarint(data[logEntryLengthOffset:], 1000)
+	})
+
+	w.openWAL(t)
+	assert.Equal(t, []string{"input01"}, w.readAllRecoverEntries())
+
+	err := w.wal.FinishRecover()
+	assert.Equal(t, true, errors.Is(err, errInvalidLogEntryLength))
+	assert.Equal(t, true, errors.Is(err, ErrCorruptEntry))
+	assert.Equal(t, "corrupt log entry: invalid length: 1000 exceeds the remaining 478 bytes: page 1", err.Error())
+}
+
+func TestWAL__Recover__Middle_Fragment_Without_First_Fragment(t *testing.T) {
+	for _, entryType := range []EntryType{EntryTypeMiddle, EntryTypeLast} {
+		w := newWalTest(t, 10, 4)
+		assert.Equal(t, nil, w.wal.FinishRecover())
+
+		w.addEntryAndNotify("input01")
+		w.addEntryAndNotify("input02")
+		w.wal.Shutdown()
+
+		// the second entry starts with a fragment that is not the first one
+		w.rewriteDiskPage(t, 1, func(page *Page) {
+			page.GetLogData()[13] = byte(entryType)
+		})
+
+		w.openWAL(t)
+		assert.Equal(t, []string{"input01"}, w.readAllRecoverEntries())
+
+		err := w.wal.FinishRecover()
+		assert.Equal(t, true, errors.Is(err, ErrCorruptEntry))
+		assert.Equal(t, fmt.Sprintf(
+			"corrupt log entry: invalid type: %d without the first fragment: page 1", entryType,
+		), err.Error())
+	}
+}
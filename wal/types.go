@@ -1,46 +1,85 @@
 package wal
 
-const (
-	PageSizeLog    = 9
-	PageSize       = 1 << PageSizeLog
-	WithinPageMask = PageSize - 1
-	PageNumMask    = ^LSN(WithinPageMask)
+import (
+	"fmt"
+)
 
-	DataSizePerPage = PageSize - pageHeaderSize
+const (
+	MinPageSizeLog     = 9  // 512 bytes
+	MaxPageSizeLog     = 16 // 64KB
+	DefaultPageSizeLog = MinPageSizeLog
+	DefaultPageSize    = 1 << DefaultPageSizeLog
 )
 
 type LSN uint64
 
-func (n LSN) ToPageNum() PageNum {
-	return PageNum(n&PageNumMask) >> PageSizeLog
+// LogDataOffset is the data part without the page header
+type LogDataOffset uint64
+
+type PageNum uint64
+
+// PageLayout is the page size of a WAL file, chosen when creating the file.
+// All conversions between lsn, page number and offset use the page size of the layout
+type PageLayout struct {
+	sizeLog uint8
 }
 
-func (n LSN) ToOffset() LogDataOffset {
-	pageNum := n.ToPageNum()
-	startLSN := LSN(pageNum << PageSizeLog)
-	index := LogDataOffset(n - startLSN)
-	return LogDataOffset(pageNum)*DataSizePerPage + index - pageHeaderSize
+// NewPageLayout returns the layout with page size = 1 << sizeLog
+func NewPageLayout(sizeLog uint8) (PageLayout, error) {
+	if sizeLog < MinPageSizeLog || sizeLog > MaxPageSizeLog {
+		return PageLayout{}, fmt.Errorf(
+			"%w: page size log %d is not in range [%d, %d]", ErrInvalidPageSize, sizeLog, MinPageSizeLog, MaxPageSizeLog,
+		)
+	}
+	return PageLayout{sizeLog: sizeLog}, nil
 }
 
-// WithinPage get the byte offset within the page
-func (n LSN) WithinPage() uint64 {
-	return uint64(n) & WithinPageMask
+func (l PageLayout) PageSizeLog() uint8 {
+	return l.sizeLog
 }
 
-type LogDataOffset uint64
+func (l PageLayout) PageSize() int64 {
+	return 1 << l.sizeLog
+}
 
-func (o LogDataOffset) ToPageNum() PageNum {
-	pageNum := uint64(o) / DataSizePerPage
-	return PageNum(pageNum)
+// DataSizePerPage is the size of the data part of a page
+func (l PageLayout) DataSizePerPage() int64 {
+	return l.PageSize() - pageHeaderSize
 }
 
-func (o LogDataOffset) ToLSN() LSN {
-	pageNum := o.ToPageNum()
-	offsetInPage := uint64(o) % DataSizePerPage
-	return LSN(uint64(pageNum)<<PageSizeLog + pageHeaderSize + offsetInPage)
+// PageStart returns the lsn of the first byte of the page
+func (l PageLayout) PageStart(num PageNum) LSN {
+	return LSN(num << l.sizeLog)
 }
 
-type PageNum uint64
+// ToPageNum returns the page that contains the lsn
+func (l PageLayout) ToPageNum(n LSN) PageNum {
+	return PageNum(n >> l.sizeLog)
+}
+
+// WithinPage get the byte offset within the page
+func (l PageLayout) WithinPage(n LSN) int64 {
+	return int64(n & LSN(l.PageSize()-1))
+}
+
+// ToOffset converts the lsn of a byte in the data part of a page to the offset
+func (l PageLayout) ToOffset(n LSN) LogDataOffset {
+	pageNum := l.ToPageNum(n)
+	index := LogDataOffset(l.WithinPage(n))
+	return LogDataOffset(pageNum)*LogDataOffset(l.DataSizePerPage()) + index - pageHeaderSize
+}
+
+// OffsetToPageNum returns the page that contains the offset
+func (l PageLayout) OffsetToPageNum(o LogDataOffset) PageNum {
+	return PageNum(uint64(o) / uint64(l.DataSizePerPage()))
+}
+
+// ToLSN converts the offset to the lsn
+func (l PageLayout) ToLSN(o LogDataOffset) LSN {
+	pageNum := l.OffsetToPageNum(o)
+	offsetInPage := uint64(o) % uint64(l.DataSizePerPage())
+	return l.PageStart(pageNum) + pageHeaderSize + LSN(offsetInPage)
+}
 
 type Epoch struct {
 	val uint32
@@ -50,8 +89,32 @@ func NewEpoch(num uint32) Epoch {
 	return Epoch{val: num}
 }
 
+// Inc increases the epoch, wraps around to zero after the max uint32
 func (e *Epoch) Inc() {
 	e.val++
 }
 
-// TODO add compare
+// Compare compares the epochs using serial number arithmetic (RFC 1982),
+// so that the order is kept after wrapping around, as long as the distance is less than 2^31.
+// Returns -1 if e is before other, 0 if equal, +1 if e is after other
+func (e Epoch) Compare(other Epoch) int {
+	diff := int32(e.val - other.val)
+	switch {
+	case diff < 0:
+		return -1
+	case diff > 0:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Before returns true if e is older than other
+func (e Epoch) Before(other Epoch) bool {
+	return e.Compare(other) < 0
+}
+
+// After returns true if e is newer than other
+func (e Epoch) After(other Epoch) bool {
+	return e.Compare(other) > 0
+}
@@ -1,11 +1,10 @@
 package types
 
 const (
-	PageSizeLog     = 9
-	PageSize        = 1 << PageSizeLog
-	WithinPageMask  = PageSize - 1
-	PageNumMask     = ^LSN(WithinPageMask)
-	DataSizePerPage = PageSize - PageHeaderSize
+	MinPageSizeLog     = 9  // 512 bytes
+	MaxPageSizeLog     = 16 // 64KB
+	DefaultPageSizeLog = MinPageSizeLog
+	DefaultPageSize    = 1 << DefaultPageSizeLog
 )
 
 const (
@@ -1,30 +1,67 @@
 package types
 
 import (
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestPageSize(t *testing.T) {
-	assert.Equal(t, 512, PageSize)
+	assert.Equal(t, 512, DefaultPageSize)
+}
+
+func TestNewPageLayout(t *testing.T) {
+	l, err := NewPageLayout(12)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(4096), l.PageSize())
+	assert.Equal(t, int64(4096-PageHeaderSize), l.DataSizePerPage())
+
+	_, err = NewPageLayout(8)
+	assert.Equal(t, "page size log 8 is not in range [9, 16]", err.Error())
 }
 
 func TestLSN_ToPageNum(t *testing.T) {
+	l, _ := NewPageLayout(DefaultPageSizeLog)
 	n := LSN(0b110111_1010_00101)
-	assert.Equal(t, PageNum(0b110111), n.ToPageNum())
+	assert.Equal(t, PageNum(0b110111), l.ToPageNum(n))
 }
 
 func TestLogDataOffset_ToLSN(t *testing.T) {
-	offset := LogDataOffset(DataSizePerPage)
-	assert.Equal(t, LSN(PageSize+PageHeaderSize), offset.ToLSN())
-	assert.Equal(t, offset, offset.ToLSN().ToOffset())
+	l, _ := NewPageLayout(DefaultPageSizeLog)
+	dataSize := LogDataOffset(l.DataSizePerPage())
+
+	offset := dataSize
+	assert.Equal(t, LSN(DefaultPageSize+PageHeaderSize), l.ToLSN(offset))
+	assert.Equal(t, offset, l.ToOffset(l.ToLSN(offset)))
+
+	offset = dataSize + 1
+	assert.Equal(t, LSN(DefaultPageSize+PageHeaderSize+1), l.ToLSN(offset))
+	assert.Equal(t, offset, l.ToOffset(l.ToLSN(offset)))
+
+	offset = 2*dataSize + dataSize - 1 // last byte of page 2
+	assert.Equal(t, LSN(3*DefaultPageSize-1), l.ToLSN(offset))
+	assert.Equal(t, offset, l.ToOffset(l.ToLSN(offset)))
+}
+
+func TestEpoch_Compare(t *testing.T) {
+	assert.Equal(t, 0, NewEpoch(5).Compare(NewEpoch(5)))
+	assert.Equal(t, -1, NewEpoch(5).Compare(NewEpoch(6)))
+	assert.Equal(t, 1, NewEpoch(6).Compare(NewEpoch(5)))
+
+	// wrap around
+	assert.Equal(t, -1, NewEpoch(math.MaxUint32).Compare(NewEpoch(0)))
+	assert.Equal(t, 1, NewEpoch(2).Compare(NewEpoch(math.MaxUint32-3)))
+	assert.Equal(t, true, NewEpoch(math.MaxUint32).Before(NewEpoch(1)))
+	assert.Equal(t, true, NewEpoch(1).After(NewEpoch(math.MaxUint32)))
 
-	offset = LogDataOffset(DataSizePerPage) + 1
-	assert.Equal(t, LSN(PageSize+PageHeaderSize+1), offset.ToLSN())
-	assert.Equal(t, offset, offset.ToLSN().ToOffset())
+	// distance less than 2^31
+	assert.Equal(t, true, NewEpoch(0).Before(NewEpoch(math.MaxInt32)))
+	assert.Equal(t, true, NewEpoch(0).After(NewEpoch(math.MaxInt32+2)))
 
-	offset = LogDataOffset(2*DataSizePerPage) + DataSizePerPage - 1 // last byte of page 2
-	assert.Equal(t, LSN(3*PageSize-1), offset.ToLSN())
-	assert.Equal(t, offset, offset.ToLSN().ToOffset())
+	e := NewEpoch(math.MaxUint32)
+	e.Inc()
+	assert.Equal(t, NewEpoch(0), e)
+	assert.Equal(t, false, e.After(e))
+	assert.Equal(t, false, e.Before(e))
 }
@@ -2,7 +2,7 @@ package types
 
 // WalWriter is thread safe
 type WalWriter interface {
-	NewEntry(dataLen int64) LogEntryWriter
+	NewEntry(dataLen int64) (LogEntryWriter, error)
 }
 
 // LogEntryWriter is NOT thread safe
@@ -1,30 +1,101 @@
 package wal
 
 import (
+	"errors"
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
+// page size used by the tests
+const (
+	testPageSize        = DefaultPageSize
+	testDataSizePerPage = testPageSize - pageHeaderSize
+)
+
+var testLayout, _ = NewPageLayout(DefaultPageSizeLog)
+
 func TestPageSize(t *testing.T) {
-	assert.Equal(t, 512, PageSize)
+	assert.Equal(t, 512, DefaultPageSize)
+	assert.Equal(t, int64(512), testLayout.PageSize())
+	assert.Equal(t, int64(494), testLayout.DataSizePerPage())
+}
+
+func TestNewPageLayout(t *testing.T) {
+	l, err := NewPageLayout(12)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(4096), l.PageSize())
+	assert.Equal(t, uint8(12), l.PageSizeLog())
+
+	_, err = NewPageLayout(8)
+	assert.Equal(t, true, errors.Is(err, ErrInvalidPageSize))
+	assert.Equal(t, "invalid page size: page size log 8 is not in range [9, 16]", err.Error())
+
+	_, err = NewPageLayout(17)
+	assert.Equal(t, "invalid page size: page size log 17 is not in range [9, 16]", err.Error())
 }
 
 func TestLSN_ToPageNum(t *testing.T) {
 	n := LSN(0b110111_1010_00101)
-	assert.Equal(t, PageNum(0b110111), n.ToPageNum())
+	assert.Equal(t, PageNum(0b110111), testLayout.ToPageNum(n))
+	assert.Equal(t, int64(0b1010_00101), testLayout.WithinPage(n))
+
+	l, _ := NewPageLayout(12)
+	n = LSN(0b110111_1010_0010_1010)
+	assert.Equal(t, PageNum(0b110111), l.ToPageNum(n))
+	assert.Equal(t, int64(0b1010_0010_1010), l.WithinPage(n))
+	assert.Equal(t, LSN(0b110111_0000_0000_0000), l.PageStart(0b110111))
 }
 
 func TestLogDataOffset_ToLSN(t *testing.T) {
-	offset := LogDataOffset(DataSizePerPage)
-	assert.Equal(t, LSN(PageSize+pageHeaderSize), offset.ToLSN())
-	assert.Equal(t, offset, offset.ToLSN().ToOffset())
+	l := testLayout
+
+	offset := LogDataOffset(testDataSizePerPage)
+	assert.Equal(t, LSN(testPageSize+pageHeaderSize), l.ToLSN(offset))
+	assert.Equal(t, offset, l.ToOffset(l.ToLSN(offset)))
+
+	offset = LogDataOffset(testDataSizePerPage) + 1
+	assert.Equal(t, LSN(testPageSize+pageHeaderSize+1), l.ToLSN(offset))
+	assert.Equal(t, offset, l.ToOffset(l.ToLSN(offset)))
+
+	offset = LogDataOffset(2*testDataSizePerPage) + testDataSizePerPage - 1 // last byte of page 2
+	assert.Equal(t, LSN(3*testPageSize-1), l.ToLSN(offset))
+	assert.Equal(t, offset, l.ToOffset(l.ToLSN(offset)))
+}
+
+func TestLogDataOffset_ToLSN__Page_Size_4KB(t *testing.T) {
+	l, _ := NewPageLayout(12)
+	dataSize := LogDataOffset(4096 - pageHeaderSize)
+
+	offset := 3*dataSize + 5
+	assert.Equal(t, PageNum(3), l.OffsetToPageNum(offset))
+	assert.Equal(t, LSN(3*4096+pageHeaderSize+5), l.ToLSN(offset))
+	assert.Equal(t, offset, l.ToOffset(l.ToLSN(offset)))
+
+	offset = 4*dataSize - 1 // last byte of page 3
+	assert.Equal(t, LSN(4*4096-1), l.ToLSN(offset))
+	assert.Equal(t, offset, l.ToOffset(l.ToLSN(offset)))
+}
+
+func TestEpoch_Compare(t *testing.T) {
+	assert.Equal(t, 0, NewEpoch(5).Compare(NewEpoch(5)))
+	assert.Equal(t, -1, NewEpoch(5).Compare(NewEpoch(6)))
+	assert.Equal(t, 1, NewEpoch(6).Compare(NewEpoch(5)))
+
+	// wrap around
+	assert.Equal(t, -1, NewEpoch(math.MaxUint32).Compare(NewEpoch(0)))
+	assert.Equal(t, 1, NewEpoch(2).Compare(NewEpoch(math.MaxUint32-3)))
+	assert.Equal(t, true, NewEpoch(math.MaxUint32).Before(NewEpoch(1)))
+	assert.Equal(t, true, NewEpoch(1).After(NewEpoch(math.MaxUint32)))
 
-	offset = LogDataOffset(DataSizePerPage) + 1
-	assert.Equal(t, LSN(PageSize+pageHeaderSize+1), offset.ToLSN())
-	assert.Equal(t, offset, offset.ToLSN().ToOffset())
+	// distance less than 2^31
+	assert.Equal(t, true, NewEpoch(0).Before(NewEpoch(math.MaxInt32)))
+	assert.Equal(t, true, NewEpoch(0).After(NewEpoch(math.MaxInt32+2)))
 
-	offset = LogDataOffset(2*DataSizePerPage) + DataSizePerPage - 1 // last byte of page 2
-	assert.Equal(t, LSN(3*PageSize-1), offset.ToLSN())
-	assert.Equal(t, offset, offset.ToLSN().ToOffset())
+	e := NewEpoch(math.MaxUint32)
+	e.Inc()
+	assert.Equal(t, NewEpoch(0), e)
+	assert.Equal(t, false, e.After(e))
+	assert.Equal(t, false, e.Before(e))
 }
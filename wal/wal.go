@@ -1,90 +1,171 @@
 package wal
 
 import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"slices"
 	"sync"
 
 	"github.com/QuangTung97/go-wal/wal/filesys"
+	"github.com/QuangTung97/go-wal/wal/types"
 )
 
 type WAL struct {
 	fs          filesys.FileSystem
 	filename    string
+	options     walOptions
+	layout      PageLayout
 	diskNumPage PageNum
 	memNumPage  PageNum
 
+	checksumTable *crc32.Table // table of the checksum algorithm of the pages and the entries
+
+	file     filesys.File
+	fileLock io.Closer // exclusive lock of the WAL file, released by Shutdown
+
 	mut       sync.Mutex
 	logBuffer []byte
 	writeErr  error
 
-	latestOffset LogDataOffset
-	writtenLsn   LSN
+	latestOffset LogDataOffset // offset of the last reserved byte
+	copiedOffset LogDataOffset // offset of the last byte of the contiguous copied prefix
+	copiedLinks  map[LogDataOffset]LogDataOffset
+
+	writtenLsn LSN
+	durableLsn LSN
+
+	durableWaiter types.LSNWaiter
 
 	latestEpoch   Epoch
 	checkpointLsn LSN
 
+	// lsn of the last byte of the entries after the checkpoint lsn, in increasing order.
+	// They are the only valid checkpoint lsn
+	entryEnds []LSN
+
+	recovery *recoveryState
+
+	checkpointMut  sync.Mutex
+	masterSequence uint64 // sequence of the latest written master page, protected by checkpointMut
+
 	wg       sync.WaitGroup
 	cond     *sync.Cond
 	isClosed bool
+
+	// set by Open, cleared when FinishRecover succeeds. The log can not be appended before
+	// the end of the recovered log is known and the new epoch is durable
+	isRecovering bool
+
+	// only accessed by the background writer
+	flushBuffer []byte
 }
 
 var _ sync.Locker = &WAL{}
 
+// NewWAL opens the WAL file with the file size and the log buffer size, creates it if not exists.
+// It is the same as Open with WithFileSize and WithLogBufferSize
 func NewWAL(
 	fs filesys.FileSystem, filename string,
 	fileSize int64, logBufferSize int64,
+	options ...Option,
 ) (*WAL, error) {
+	options = append(options[:len(options):len(options)], WithFileSize(fileSize), WithLogBufferSize(logBufferSize))
+	return Open(fs, filename, options...)
+}
+
+// Open opens the WAL file, creates it if not exists.
+// The options are validated before touching the file system, returns an error wrapping ErrInvalidOption
+// if an option is not valid.
+// The WAL starts in recovery mode, the entries are read by NextRecoverEntry until FinishRecover is called.
+// Write, TryWrite, NewEntry, WaitDurable and Checkpoint return ErrRecovering until FinishRecover succeeds
+func Open(fs filesys.FileSystem, filename string, options ...Option) (*WAL, error) {
 	w := &WAL{
 		fs:       fs,
 		filename: filename,
+		options:  newWalOptions(options...),
 
-		diskNumPage: PageNum(fileSize / PageSize),
-		memNumPage:  PageNum(logBufferSize / PageSize),
+		isRecovering: true,
 	}
 
-	w.cond = sync.NewCond(&w.mut)
+	layout, err := w.options.validate()
+	if err != nil {
+		return nil, err
+	}
+	w.layout = layout
+	w.diskNumPage = PageNum(w.options.fileSize / layout.PageSize())
+	w.memNumPage = PageNum(w.options.logBufferSize / layout.PageSize())
+	w.checksumTable = w.options.checksum.table()
 
-	// TODO validate
+	w.cond = sync.NewCond(&w.mut)
 
-	w.logBuffer = make([]byte, w.memNumPage*PageSize)
+	w.logBuffer = make([]byte, int64(w.memNumPage)*layout.PageSize())
+	w.flushBuffer = make([]byte, int64(w.memNumPage)*layout.PageSize())
 
-	_, err := w.createWalFileIfNotExists()
-	if err != nil {
+	if err := w.lockWalFile(); err != nil {
+		return nil, err
+	}
+	if err := w.openWalFile(); err != nil {
+		_ = w.fileLock.Close()
 		return nil, err
 	}
 
-	w.latestOffset = DataSizePerPage - 1
+	w.latestOffset = LogDataOffset(layout.DataSizePerPage()) - 1
+	w.copiedOffset = w.latestOffset
+	w.copiedLinks = map[LogDataOffset]LogDataOffset{}
 	w.writtenLsn = w.checkpointLsn
+	w.durableLsn = w.checkpointLsn
+	w.durableWaiter = NewLSNWaiter(types.LSN(w.durableLsn))
 
-	firstPage := w.getInMemPage(w.checkpointLsn.ToPageNum())
-	InitPage(&firstPage, NewEpoch(0), w.checkpointLsn.ToPageNum())
+	firstPage := w.getInMemPage(layout.ToPageNum(w.checkpointLsn))
+	InitPage(&firstPage, NewEpoch(0), layout.ToPageNum(w.checkpointLsn))
 
-	// TODO previous log existed
+	w.recovery = newRecoveryState(layout, w.checksumTable, w.checkpointLsn, w.latestEpoch)
 
 	return w, nil
 }
 
-func (w *WAL) NextRecoverEntry() bool {
-	return false
-}
+// FinishRecover skips the entries that are not yet read by NextRecoverEntry,
+// persists the new epoch to the master page, truncates the discarded bytes after the log
+// (see GetDiscardedBytes), then starts the background writer to continue appending
+// after the last valid entry
+func (w *WAL) FinishRecover() error {
+	w.latestEpoch.Inc()
 
-type EntryReader struct {
-}
+	if err := w.finishRecovery(); err != nil {
+		return err
+	}
+	if err := w.restoreTailBackup(); err != nil {
+		return err
+	}
 
-func (r *EntryReader) Read(data []byte) (n int, hasNext bool) {
-	return len(data), false
-}
+	// the new epoch must be durable before writing any page of this epoch.
+	// The pages of older epochs after the recovered log are then rejected by the next recovery
+	if err := w.writeLatestEpoch(); err != nil {
+		return err
+	}
 
-func (w *WAL) GetRecoveryEntry() EntryReader {
-	return EntryReader{}
-}
+	if err := w.truncateLogTail(); err != nil {
+		return err
+	}
 
-func (w *WAL) FinishRecover() {
-	w.latestEpoch.Inc()
+	w.options.logger.Info(
+		"wal is recovered",
+		"checkpoint_lsn", w.checkpointLsn,
+		"last_lsn", w.recovery.lastLsn,
+		"discarded_bytes", w.recovery.discardedBytes,
+		"epoch", w.latestEpoch.val,
+	)
 
-	// TODO write the new latest epoch
+	w.mut.Lock()
+	w.isRecovering = false
+	w.mut.Unlock()
 
 	w.wg.Add(1)
 	go w.runWriterInBackground()
+
+	return nil
 }
 
 func (w *WAL) Lock() {
@@ -106,73 +187,207 @@ func (w *WAL) Shutdown() {
 		return
 	}
 
-	w.cond.Signal()
+	w.cond.Broadcast()
 	w.wg.Wait()
+	w.releaseAllWaiters()
+
+	_ = w.file.Close()
+	_ = w.fileLock.Close()
 }
 
-// Write need to be called inside mutex lock
-func (w *WAL) Write(reader ByteReader) {
-	prevPageNum := w.latestOffset.ToPageNum()
-	isSplit := false
+// Write need to be called inside mutex lock.
+// Returns the lsn of the last byte of the entry.
+// If the log buffer is full, Write notifies the background writer and blocks until
+// the pages are written to disk
+func (w *WAL) Write(reader ByteReader) (LSN, error) {
+	if err := w.checkWritable(); err != nil {
+		return 0, err
+	}
+	if err := w.checkEntrySize(reader.Len()); err != nil {
+		return 0, err
+	}
+
+	e := w.newLogEntryWriter(reader.Len(), true)
+	for reader.Len() > 0 && e.err == nil {
+		e.Write(reader.Read(reader.Len()))
+	}
+	e.writeChecksum()
+	e.finish()
+
+	if e.err != nil {
+		return 0, e.err
+	}
+	return w.layout.ToLSN(e.endOffset), nil
+}
+
+// TryWrite is the non-blocking version of Write.
+// Returns ErrLogBufferFull if the log buffer does not have enough free pages for the whole entry.
+// Returns an error wrapping ErrEntryTooLarge if the entry does not fit in the log buffer even when
+// all pages are free, retrying TryWrite never succeeds: the entry can only be written by Write.
+// Needs to be called inside mutex lock
+func (w *WAL) TryWrite(reader ByteReader) (LSN, error) {
+	if err := w.checkWritable(); err != nil {
+		return 0, err
+	}
+	if err := w.checkEntrySize(reader.Len()); err != nil {
+		return 0, err
+	}
+
+	_, lastOffset := w.entryRange(reader.Len())
+	lastPage := w.layout.OffsetToPageNum(lastOffset)
 
-	for {
-		nextLSN := (w.latestOffset + 1).ToLSN()
-		// pageEndLSN := (nextLSN & PageNumMask) + (PageSize - 1)
+	// the pages from the page of the next byte are free when all written bytes are durable
+	if lastPage >= w.layout.OffsetToPageNum(w.latestOffset+1)+w.memNumPage {
+		return 0, fmt.Errorf(
+			"%w: entry size %d does not fit in the log buffer of %d pages",
+			ErrEntryTooLarge, reader.Len(), w.memNumPage,
+		)
+	}
+	if !w.isInMemPageFree(lastPage) {
+		return 0, ErrLogBufferFull
+	}
+	return w.Write(reader)
+}
 
-		nextPageNum := nextLSN.ToPageNum()
-		if nextPageNum > prevPageNum {
-			// init the page in memory
-			page := w.getInMemPage(nextPageNum)
-			InitPage(&page, w.latestEpoch, nextPageNum)
-			prevPageNum = nextPageNum
-		}
+func (w *WAL) checkWritable() error {
+	if w.writeErr != nil {
+		return w.writeErr
+	}
+	if w.isClosed {
+		return ErrClosed
+	}
+	if w.isRecovering {
+		return ErrRecovering
+	}
+	return nil
+}
 
-		page := w.getInMemPage(nextPageNum)
-		offset := nextLSN.WithinPage()
+func (w *WAL) checkEntrySize(dataLen int64) error {
+	if dataLen > w.options.maxEntrySize {
+		return fmt.Errorf(
+			"%w: entry size %d exceeds the max entry size %d", ErrEntryTooLarge, dataLen, w.options.maxEntrySize,
+		)
+	}
+	return nil
+}
 
-		remainLen := PageSize - offset - logEntryDataOffset // must have the full entry header
-		if isSplit {
-			remainLen = PageSize - offset // if entry is split => not have to account for entry header
-		}
+// isInMemPageFree checks whether the page can be stored in the log buffer
+// without overwriting the pages that are not yet durable
+func (w *WAL) isInMemPageFree(num PageNum) bool {
+	return num < w.layout.ToPageNum(w.durableLsn+1)+w.memNumPage
+}
 
-		if remainLen <= 0 {
-			// add the remaining bytes (ignoring entry header)
-			w.latestOffset += LogDataOffset(PageSize - offset)
-			continue
-		}
+// NotifyWriter needs to be called inside mutex lock.
+// The background writer will write the contiguous prefix of copied bytes to disk
+func (w *WAL) NotifyWriter() {
+	w.writtenLsn = w.layout.ToLSN(w.copiedOffset)
+	w.cond.Broadcast()
+}
 
-		writeLen := reader.Len()
-		withBreak := true
-		if remainLen < uint64(reader.Len()) {
-			writeLen = int64(remainLen)
-			withBreak = false
-		}
+// WaitDurable blocks until all bytes up to lsn have been fsync-ed to disk.
+// Many concurrent callers share the same fsync of the background writer.
+// Must NOT be called inside mutex lock
+func (w *WAL) WaitDurable(lsn LSN) error {
+	w.mut.Lock()
+	isRecovering := w.isRecovering
+	w.mut.Unlock()
+	if isRecovering {
+		// the background writer is not started
+		return ErrRecovering
+	}
 
-		if isSplit {
-			written := WriteLogEntryDataOnly(page.data[offset:], reader, writeLen)
-			w.latestOffset += LogDataOffset(written)
-		} else {
-			written := WriteLogEntry(page.data[offset:], EntryTypeNormal, reader, writeLen)
-			w.latestOffset += LogDataOffset(written)
-		}
+	w.durableWaiter.WaitLSN(types.LSN(lsn))
 
-		if withBreak {
-			break
-		}
+	w.mut.Lock()
+	defer w.mut.Unlock()
 
-		isSplit = true
+	if lsn <= w.durableLsn {
+		return nil
+	}
+	if w.writeErr != nil {
+		return w.writeErr
 	}
+	return ErrClosed
 }
 
-// NotifyWriter needs to be called inside mutex lock
-func (w *WAL) NotifyWriter() {
-	w.writtenLsn = w.latestOffset.ToLSN()
-	w.cond.Signal()
+// GetDurableLSN returns the highest lsn that has been fsync-ed to disk.
+// Needs to be called inside mutex lock
+func (w *WAL) GetDurableLSN() LSN {
+	return w.durableLsn
+}
+
+// Err returns the error of the background writer, or nil if no write or fsync has failed.
+// The error is sticky: once it is set, all Write and WaitDurable calls return it.
+// Must NOT be called inside mutex lock
+func (w *WAL) Err() error {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	return w.writeErr
+}
+
+// releaseAllWaiters wakes up all WaitDurable callers when the background writer stops
+func (w *WAL) releaseAllWaiters() {
+	w.durableWaiter.SetLSN(math.MaxUint64)
+}
+
+// masterNumPage returns the number of pages at the start of the WAL file
+// that store the slots of the master page
+func (w *WAL) masterNumPage() PageNum {
+	return masterNumPageOf(w.layout.PageSize())
+}
+
+// tailBackupNumPage returns the number of pages after the master page used as the tail backup page
+func (w *WAL) tailBackupNumPage() PageNum {
+	return tailBackupNumPageOf(w.layout.PageSize())
+}
+
+// tailBackupOffset returns the offset of the tail backup page in the WAL file
+func (w *WAL) tailBackupOffset() int64 {
+	return int64(w.masterNumPage()) * w.layout.PageSize()
+}
+
+// diskRingNumPage returns the number of pages on disk used for the log.
+// Pages of the log are stored as a ring over the pages after the master page and the tail backup page,
+// the space before the checkpoint lsn is reused
+func (w *WAL) diskRingNumPage() PageNum {
+	return w.diskNumPage - w.masterNumPage() - w.tailBackupNumPage()
+}
+
+// diskPageIndex returns the index of the page in the ring on disk
+func (w *WAL) diskPageIndex(num PageNum) PageNum {
+	return (num - 1) % w.diskRingNumPage()
+}
+
+// diskPageOffset returns the offset of the page in the WAL file
+func (w *WAL) diskPageOffset(num PageNum) int64 {
+	return int64(w.diskPageIndex(num)+w.masterNumPage()+w.tailBackupNumPage()) * w.layout.PageSize()
+}
+
+// releaseLogSpace sets the checkpoint lsn in memory.
+// The pages on disk before it can be overwritten by the background writer
+func (w *WAL) releaseLogSpace(lsn LSN) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+
+	if lsn <= w.checkpointLsn {
+		return
+	}
+	w.checkpointLsn = lsn
+
+	index, found := slices.BinarySearch(w.entryEnds, lsn)
+	if found {
+		index++
+	}
+	w.entryEnds = w.entryEnds[index:]
+
+	w.cond.Broadcast()
 }
 
 func (w *WAL) getInMemPage(num PageNum) Page {
-	offset := num % w.memNumPage
+	offset := int64(num % w.memNumPage)
+	pageSize := w.layout.PageSize()
 	return Page{
-		data: w.logBuffer[offset*PageSize : (offset+1)*PageSize],
+		data:     w.logBuffer[offset*pageSize : (offset+1)*pageSize],
+		crcTable: w.checksumTable,
 	}
 }
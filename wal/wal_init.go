@@ -1,9 +1,52 @@
 package wal
 
 import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
 	"github.com/QuangTung97/go-wal/wal/filesys"
 )
 
+// lockFileSuffix is appended to the name of the WAL file to get the name of its lock file.
+// The WAL file itself is not locked: it is replaced by renaming when created
+const lockFileSuffix = ".lock"
+
+// lockWalFile takes the exclusive lock of the WAL file before reading or creating it,
+// so two processes can never write to the same WAL file
+func (w *WAL) lockWalFile() error {
+	lock, err := w.fs.LockFile(w.filename + lockFileSuffix)
+	if err != nil {
+		if errors.Is(err, filesys.ErrLocked) {
+			return fmt.Errorf("%w: wal file %s is already opened", ErrFileLocked, w.filename)
+		}
+		return newIOError("lock", err)
+	}
+	w.fileLock = lock
+	return nil
+}
+
+// openWalFile creates the WAL file if not exists, then opens it and reads the master page
+func (w *WAL) openWalFile() error {
+	existed, err := w.createWalFileIfNotExists()
+	if err != nil {
+		return newIOError("create", err)
+	}
+
+	w.file, err = w.fs.OpenFile(w.filename)
+	if err != nil {
+		return newIOError("open", err)
+	}
+
+	if existed {
+		if err := w.readMasterPageFromFile(); err != nil {
+			_ = w.file.Close()
+			return err
+		}
+	}
+	return nil
+}
+
 func (w *WAL) createWalFileIfNotExists() (bool, error) {
 	existed, err := w.fs.Exists(w.filename)
 	if err != nil {
@@ -22,39 +65,93 @@ func (w *WAL) createWalFileIfNotExists() (bool, error) {
 		return false, err
 	}
 
-	// TODO fsync the parent dir
+	// make the renaming durable
+	if err := w.fs.SyncDir(filepath.Dir(w.filename)); err != nil {
+		return false, err
+	}
 
 	return false, nil
 }
 
+// createTemporaryWalFile creates the fully initialized WAL file and fsyncs it before renaming,
+// so that the WAL file is never seen half initialized after crashing
 func (w *WAL) createTemporaryWalFile(tempFileName string) error {
-	writer, err := w.fs.CreateEmptyFile(tempFileName, int64(w.diskNumPage)*PageSize)
+	fileSize := int64(w.diskNumPage) * w.layout.PageSize()
+	file, err := w.fs.CreateEmptyFile(tempFileName, fileSize)
 	if err != nil {
 		return err
 	}
 
 	// setup closer
-	closer := filesys.NewIdempotentCloser(writer)
+	closer := filesys.NewIdempotentCloser(file)
 	defer closer.CloseIgnoreError()
 
 	w.latestEpoch = NewEpoch(0)
-	w.checkpointLsn = PageSize - 1
+	w.checkpointLsn = LSN(w.layout.PageSize() - 1)
 
-	masterPage := &MasterPage{
-		Version:       MasterPageFirstVersion,
-		LatestEpoch:   w.latestEpoch,
-		CheckpointLSN: w.checkpointLsn,
+	masterPage := w.newMasterPage(w.checkpointLsn, w.latestEpoch)
+	if err := WriteMasterPageSlot(file, masterPage); err != nil {
+		return err
 	}
+	w.masterSequence = masterPage.Sequence
 
-	if err := WriteMasterPage(writer, masterPage); err != nil {
+	// the other slot of the master page and the log pages are filled with zeros.
+	// They have invalid checksums => recovery sees an empty log
+	if err := writeZeros(file, masterPageSize, fileSize); err != nil {
 		return err
 	}
 
-	// Write to first page
+	if err := file.Sync(); err != nil {
+		return err
+	}
 
-	// TODO write to remaining pages
+	return closer.Close()
+}
 
-	// TODO fsync the tmp file
+const zeroChunkSize = 64 << 10
 
-	return closer.Close()
+// writeZeros writes zeros to range [from, to) of the file
+func writeZeros(file filesys.File, from int64, to int64) error {
+	zeros := make([]byte, min(zeroChunkSize, max(to-from, 0)))
+	for offset := from; offset < to; offset += zeroChunkSize {
+		n := min(zeroChunkSize, to-offset)
+		if _, err := file.WriteAt(zeros[:n], offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *WAL) readMasterPageFromFile() error {
+	var masterPage MasterPage
+	if err := ReadLatestMasterPage(w.file, &masterPage); err != nil {
+		return err
+	}
+
+	if masterPage.PageSizeLog != w.layout.PageSizeLog() {
+		return fmt.Errorf(
+			"%w: page size %d of the WAL file is different from the configured page size %d",
+			ErrIncompatibleFile, int64(1)<<masterPage.PageSizeLog, w.layout.PageSize(),
+		)
+	}
+
+	// the pages of the log are stored as a ring over the pages of the file
+	if masterPage.NumPage != w.diskNumPage {
+		return fmt.Errorf(
+			"%w: file size %d of the WAL file is different from the configured file size %d",
+			ErrIncompatibleFile, int64(masterPage.NumPage)*w.layout.PageSize(), w.options.fileSize,
+		)
+	}
+
+	if masterPage.Checksum != w.options.checksum {
+		return fmt.Errorf(
+			"%w: checksum algorithm %d of the WAL file is different from the configured checksum algorithm %d",
+			ErrIncompatibleFile, masterPage.Checksum, w.options.checksum,
+		)
+	}
+
+	w.latestEpoch = masterPage.LatestEpoch
+	w.checkpointLsn = masterPage.CheckpointLSN
+	w.masterSequence = masterPage.Sequence
+	return nil
 }
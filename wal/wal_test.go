@@ -2,10 +2,16 @@ package wal
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -18,59 +24,81 @@ func joinStrings(list ...string) string {
 }
 
 type walTest struct {
+	fs       filesys.FileSystem
 	filename string
 	wal      *WAL
+
+	pageOnDisk int64
+	pageOnMem  int64
+	options    []Option
+}
+
+func newWalTest(t *testing.T, pageOnDisk int64, pageOnMem int64, options ...Option) *walTest {
+	return newWalTestOnFS(t, filesys.NewMemFileSystem(), "/data/wal01", pageOnDisk, pageOnMem, options...)
 }
 
-func newWalTest(t *testing.T, pageOnDisk int64, pageOnMem int64) *walTest {
-	w := &walTest{}
+func newWalTestOnFS(
+	t *testing.T, fs filesys.FileSystem, filename string,
+	pageOnDisk int64, pageOnMem int64, options ...Option,
+) *walTest {
+	w := &walTest{
+		fs:       fs,
+		filename: filename,
+
+		pageOnDisk: pageOnDisk,
+		pageOnMem:  pageOnMem,
+		options:    options,
+	}
 
-	tempDir := t.TempDir()
-	w.filename = filepath.Join(tempDir, "wal01")
-	fs := filesys.NewFileSystem()
+	w.openWAL(t)
+	return w
+}
 
+func (w *walTest) openWAL(t *testing.T) {
 	var err error
-	// 2 pages in mem
-	// 4 pages on disk
-	w.wal, err = NewWAL(fs, w.filename, PageSize*pageOnDisk, PageSize*pageOnMem)
+	pageSize := newWalOptions(w.options...).pageSize
+	w.wal, err = NewWAL(w.fs, w.filename, pageSize*w.pageOnDisk, pageSize*w.pageOnMem, w.options...)
 	if err != nil {
 		panic(err)
 	}
 
 	t.Cleanup(w.wal.Shutdown)
+}
 
-	return w
+// reopen shutdowns the current WAL and opens a new one on the same file
+func (w *walTest) reopen(t *testing.T) {
+	w.wal.Shutdown()
+	w.openWAL(t)
 }
 
 func TestWAL__Init_And_Check_Master_Page(t *testing.T) {
 	w := newWalTest(t, 5, 2)
 
 	// check file size
-	fileStat, err := os.Stat(w.filename)
-	require.Equal(t, nil, err)
-	assert.Equal(t, int64(512*5), fileStat.Size())
+	allData := w.readFile(t)
+	assert.Equal(t, 512*5, len(allData))
 
 	// check file content
-	allData, err := os.ReadFile(w.filename)
-	require.Equal(t, nil, err)
 	reader := bytes.NewReader(allData)
 
 	// check master page
 	var masterPage MasterPage
-	err = ReadMasterPage(reader, &masterPage)
+	err := ReadMasterPage(reader, &masterPage)
 	require.Equal(t, nil, err)
 	require.Equal(t, MasterPage{
 		Version:       1,
 		LatestEpoch:   NewEpoch(0),
 		CheckpointLSN: 511,
+		PageSizeLog:   9,
+		NumPage:       5,
 	}, masterPage)
 
 	// check init data
-	assert.Equal(t, PageSize*2, len(w.wal.logBuffer))
-	assert.Equal(t, LogDataOffset(DataSizePerPage-1), w.wal.latestOffset)
-	assert.Equal(t, LSN(PageSize-1), w.wal.writtenLsn)
+	assert.Equal(t, testPageSize*2, len(w.wal.logBuffer))
+	assert.Equal(t, LogDataOffset(testDataSizePerPage-1), w.wal.latestOffset)
+	assert.Equal(t, LSN(testPageSize-1), w.wal.writtenLsn)
 	assert.Equal(t, NewEpoch(0), w.wal.latestEpoch)
-	assert.Equal(t, LSN(PageSize-1), w.wal.checkpointLsn)
+	assert.Equal(t, LSN(testPageSize-1), w.wal.checkpointLsn)
 
 	// get first page
 	firstPage := w.wal.getInMemPage(0)
@@ -104,7 +132,7 @@ func TestWAL__Add_Entry__Check_In_Memory(t *testing.T) {
 	it := secondPage.newIterator()
 	assert.Equal(t, true, it.next())
 	assert.Equal(t, EntryTypeNormal, it.entryType)
-	assert.Equal(t, "test 01", string(it.entryData))
+	assert.Equal(t, withChecksum("test 01"), string(it.entryData))
 
 	// check third page, not yet init
 	page3 := w.wal.getInMemPage(2)
@@ -138,12 +166,12 @@ func TestWAL__Add_Big_Entry__Check_In_Memory(t *testing.T) {
 	it := page2.newIterator()
 	assert.Equal(t, true, it.next())
 	assert.Equal(t, EntryTypeNormal, it.entryType)
-	assert.Equal(t, "input01", string(it.entryData))
+	assert.Equal(t, withChecksum("input01"), string(it.entryData))
 
-	// next entry
+	// first fragment of the big entry
 	assert.Equal(t, true, it.next())
-	assert.Equal(t, EntryTypeNormal, it.entryType)
-	assert.Equal(t, strings.Repeat("A", 200)+strings.Repeat("B", 281), string(it.entryData))
+	assert.Equal(t, EntryTypeFirst, it.entryType)
+	assert.Equal(t, strings.Repeat("A", 200)+strings.Repeat("B", 278), string(it.entryData))
 
 	// no next
 	assert.Equal(t, false, it.next())
@@ -156,10 +184,11 @@ func TestWAL__Add_Big_Entry__Check_In_Memory(t *testing.T) {
 	assert.Equal(t, NewEpoch(1), page3.GetEpoch())
 	assert.Equal(t, PageNum(2), page3.GetPageNum())
 
-	assert.Equal(t,
-		strings.Repeat("B", 19)+strings.Repeat("C", 512-19-pageHeaderSize),
-		string(page3.GetLogData()),
-	)
+	it = page3.newIterator()
+	assert.Equal(t, true, it.next())
+	assert.Equal(t, EntryTypeMiddle, it.entryType)
+	assert.Equal(t, strings.Repeat("B", 22)+strings.Repeat("C", 469), string(it.entryData))
+	assert.Equal(t, false, it.next())
 
 	// ----------------------------
 	// check forth page
@@ -169,7 +198,14 @@ func TestWAL__Add_Big_Entry__Check_In_Memory(t *testing.T) {
 	assert.Equal(t, NewEpoch(1), page4.GetEpoch())
 	assert.Equal(t, PageNum(3), page4.GetPageNum())
 
-	assert.Equal(t, strings.Repeat("C", 25)+"\x00", string(page4.GetLogData()[:26]))
+	it = page4.newIterator()
+	assert.Equal(t, true, it.next())
+	assert.Equal(t, EntryTypeLast, it.entryType)
+	assert.Equal(t, withChecksum(inputStr)[200+278+491:], string(it.entryData))
+	assert.Equal(t, 31+entryChecksumSize, len(it.entryData))
+
+	assert.Equal(t, true, it.next())
+	assert.Equal(t, EntryTypeNone, it.entryType)
 }
 
 func TestWAL__Add_Entry__Over_Max_Page(t *testing.T) {
@@ -180,7 +216,7 @@ func TestWAL__Add_Entry__Over_Max_Page(t *testing.T) {
 
 	inputStr := joinStrings(
 		strings.Repeat("A", 200),
-		strings.Repeat("B", 281-3),
+		strings.Repeat("B", 263),
 	)
 	w.addEntry(inputStr) // add big entry
 
@@ -197,21 +233,19 @@ func TestWAL__Add_Entry__Over_Max_Page(t *testing.T) {
 	// check first entry
 	it := page2.newIterator()
 	assert.Equal(t, true, it.next())
-	assert.Equal(t, EntryTypeFull, it.entryType)
-	assert.Equal(t, "input01", string(it.entryData))
+	assert.Equal(t, EntryTypeNormal, it.entryType)
+	assert.Equal(t, withChecksum("input01"), string(it.entryData))
 
 	// next entry
 	assert.Equal(t, true, it.next())
-	assert.Equal(t, EntryTypeFull, it.entryType)
-	assert.Equal(t, strings.Repeat("A", 200)+strings.Repeat("B", 281-3), string(it.entryData))
+	assert.Equal(t, EntryTypeNormal, it.entryType)
+	assert.Equal(t, withChecksum(inputStr), string(it.entryData))
 
-	// none entry
-	assert.Equal(t, true, it.next())
-	assert.Equal(t, EntryTypeNone, it.entryType)
-	assert.Equal(t, true, it.next())
-	assert.Equal(t, EntryTypeNone, it.entryType)
-	assert.Equal(t, true, it.next())
-	assert.Equal(t, EntryTypeNone, it.entryType)
+	// none entry, not enough space for the entry header
+	for i := 0; i < maxLogEntryHeaderSize; i++ {
+		assert.Equal(t, true, it.next())
+		assert.Equal(t, EntryTypeNone, it.entryType)
+	}
 	assert.Equal(t, false, it.next()) // end here
 
 	// ----------------------------
@@ -225,8 +259,8 @@ func TestWAL__Add_Entry__Over_Max_Page(t *testing.T) {
 	// check first entry of third page
 	it = page3.newIterator()
 	assert.Equal(t, true, it.next())
-	assert.Equal(t, EntryTypeFull, it.entryType)
-	assert.Equal(t, "y", string(it.entryData))
+	assert.Equal(t, EntryTypeNormal, it.entryType)
+	assert.Equal(t, withChecksum("y"), string(it.entryData))
 }
 
 func TestWAL__Add_3_Entry__Fit_Page(t *testing.T) {
@@ -237,11 +271,11 @@ func TestWAL__Add_3_Entry__Fit_Page(t *testing.T) {
 
 	inputStr := joinStrings(
 		strings.Repeat("A", 200),
-		strings.Repeat("B", 281-4),
+		strings.Repeat("B", 256),
 	)
 	w.addEntry(inputStr) // add big entry
 
-	w.addEntry("y") // add single byte
+	w.addEntry("yyyyyyyyyyyy") // add small entry
 
 	// ----------------------------
 	// check second page
@@ -254,18 +288,18 @@ func TestWAL__Add_3_Entry__Fit_Page(t *testing.T) {
 	// check first entry
 	it := page2.newIterator()
 	assert.Equal(t, true, it.next())
-	assert.Equal(t, EntryTypeFull, it.entryType)
-	assert.Equal(t, "input01", string(it.entryData))
+	assert.Equal(t, EntryTypeNormal, it.entryType)
+	assert.Equal(t, withChecksum("input01"), string(it.entryData))
 
 	// next entry
 	assert.Equal(t, true, it.next())
-	assert.Equal(t, EntryTypeFull, it.entryType)
-	assert.Equal(t, strings.Repeat("A", 200)+strings.Repeat("B", 281-4), string(it.entryData))
+	assert.Equal(t, EntryTypeNormal, it.entryType)
+	assert.Equal(t, withChecksum(inputStr), string(it.entryData))
 
 	// next entry
 	assert.Equal(t, true, it.next())
-	assert.Equal(t, EntryTypeFull, it.entryType)
-	assert.Equal(t, "y", string(it.entryData))
+	assert.Equal(t, EntryTypeNormal, it.entryType)
+	assert.Equal(t, withChecksum("yyyyyyyyyyyy"), string(it.entryData))
 
 	// end
 	assert.Equal(t, false, it.next())
@@ -279,9 +313,790 @@ func TestWAL__Add_3_Entry__Fit_Page(t *testing.T) {
 	assert.Equal(t, PageNum(0), page3.GetPageNum())
 }
 
-func TestWAL__Add_Entry_Then_Recover(t *testing.T) {
+// newFullPageEntry returns an entry that fills the whole data part of a page
+func newFullPageEntry(c byte) string {
+	return strings.Repeat(string(c), int(fragmentCapacity(testDataSizePerPage))-entryChecksumSize)
+}
+
+func (w *walTest) addEntryAndNotify(input string) LSN {
+	w.wal.Lock()
+	defer w.wal.Unlock()
+
+	lsn, err := w.wal.Write(NewSimpleByteReader([]byte(input)))
+	if err != nil {
+		panic(err)
+	}
+	w.wal.NotifyWriter()
+	return lsn
+}
+
+func (w *walTest) readDiskPage(t *testing.T, num PageNum) *Page {
+	file, err := w.fs.OpenFile(w.filename)
+	require.Equal(t, nil, err)
+	defer func() { _ = file.Close() }()
+
+	page := newTestPage()
+	page.crcTable = w.wal.checksumTable
+	reader := io.NewSectionReader(file, w.wal.diskPageOffset(num), testPageSize)
+	err = ReadPage(page, reader)
+	require.Equal(t, nil, err)
+	return page
+}
+
+// readFile returns the current content of the WAL file
+func (w *walTest) readFile(t *testing.T) []byte {
+	file, err := w.fs.OpenFile(w.filename)
+	require.Equal(t, nil, err)
+	defer func() { _ = file.Close() }()
+
+	data, err := io.ReadAll(io.NewSectionReader(file, 0, math.MaxInt64))
+	require.Equal(t, nil, err)
+	return data
+}
+
+func TestWAL__Add_Entry__Flush_To_Disk(t *testing.T) {
+	w := newWalTest(t, 100, 20)
+	w.wal.FinishRecover()
+
+	w.addEntryAndNotify("input01")
+
+	inputStr := joinStrings(
+		strings.Repeat("A", 200),
+		strings.Repeat("B", 300),
+	)
+	w.addEntryAndNotify(inputStr)
+
+	w.wal.Shutdown()
+
+	assert.Equal(t, LSN(2*testPageSize+pageHeaderSize+2+22+entryChecksumSize-1), w.wal.GetDurableLSN())
+	assert.Equal(t, nil, w.wal.writeErr)
+
+	// check second page
+	page2 := w.readDiskPage(t, 1)
+	assert.Equal(t, NewEpoch(1), page2.GetEpoch())
+	assert.Equal(t, PageNum(1), page2.GetPageNum())
+	assert.Equal(t, w.wal.getInMemPage(1).data, page2.data)
+	assert.Equal(t, false, page2.GetFlags().IsNotFull())
+
+	it := page2.newIterator()
+	assert.Equal(t, true, it.next())
+	assert.Equal(t, withChecksum("input01"), string(it.entryData))
+
+	// check third page
+	page3 := w.readDiskPage(t, 2)
+	assert.Equal(t, NewEpoch(1), page3.GetEpoch())
+	assert.Equal(t, PageNum(2), page3.GetPageNum())
+	assert.Equal(t, true, page3.GetFlags().IsNotFull())
+	assert.Equal(t, false, page3.GetFlags().IsTruncated())
+
+	it = page3.newIterator()
+	assert.Equal(t, true, it.next())
+	assert.Equal(t, EntryTypeLast, it.entryType)
+	assert.Equal(t, withChecksum(inputStr)[200+278:], string(it.entryData))
+}
+
+func TestWAL__Flush_To_Disk__Not_Notified_Bytes_Are_Not_Written(t *testing.T) {
+	w := newWalTest(t, 100, 20)
+	w.wal.FinishRecover()
+
+	w.addEntryAndNotify("input01")
+
+	w.wal.Lock()
+	w.addEntry("input02")
+	w.wal.Unlock()
+
+	w.wal.Shutdown()
+
+	assert.Equal(t, LSN(testPageSize+pageHeaderSize+13-1), w.wal.GetDurableLSN())
+
+	page2 := w.readDiskPage(t, 1)
+	it := page2.newIterator()
+	assert.Equal(t, true, it.next())
+	assert.Equal(t, withChecksum("input01"), string(it.entryData))
+
+	assert.Equal(t, true, it.next())
+	assert.Equal(t, EntryTypeNone, it.entryType)
+}
+
+func TestWAL__Wait_Durable__Concurrent_Committers(t *testing.T) {
 	w := newWalTest(t, 100, 20)
 	w.wal.FinishRecover()
 
+	const numWriters = 20
+
+	var wg sync.WaitGroup
+	for i := 0; i < numWriters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			w.wal.Lock()
+			lsn, err := w.wal.Write(NewSimpleByteReader([]byte(fmt.Sprintf("entry %02d", i))))
+			assert.Equal(t, nil, err)
+			w.wal.NotifyWriter()
+			w.wal.Unlock()
+
+			err = w.wal.WaitDurable(lsn)
+			assert.Equal(t, nil, err)
+
+			w.wal.Lock()
+			assert.GreaterOrEqual(t, w.wal.GetDurableLSN(), lsn)
+			w.wal.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	w.wal.Lock()
+	assert.Equal(t, w.wal.writtenLsn, w.wal.GetDurableLSN())
+	w.wal.Unlock()
+
+	// check on disk
+	page2 := w.readDiskPage(t, 1)
+	it := page2.newIterator()
+	for i := 0; i < numWriters; i++ {
+		assert.Equal(t, true, it.next())
+		assert.Equal(t, EntryTypeNormal, it.entryType)
+		assert.Equal(t, 8+entryChecksumSize, len(it.entryData))
+	}
+}
+
+func TestWAL__Wait_Durable__After_Shutdown(t *testing.T) {
+	w := newWalTest(t, 100, 20)
+	w.wal.FinishRecover()
+
+	w.wal.Lock()
+	lsn, err := w.wal.Write(NewSimpleByteReader([]byte("input01")))
+	w.wal.Unlock()
+	assert.Equal(t, nil, err)
+
+	w.wal.Shutdown()
+
+	// not yet notified => never written
+	err = w.wal.WaitDurable(lsn)
+	assert.Equal(t, ErrClosed, err)
+
+	// already durable
+	err = w.wal.WaitDurable(testPageSize - 1)
+	assert.Equal(t, nil, err)
+}
+
+func (w *walTest) writeMasterPage(t *testing.T, checkpointLsn LSN) {
+	file, err := w.fs.OpenFile(w.filename)
+	require.Equal(t, nil, err)
+	defer func() { _ = file.Close() }()
+
+	masterPage := w.readMasterPage(t)
+	masterPage.CheckpointLSN = checkpointLsn
+	masterPage.Sequence++
+	err = WriteMasterPageSlot(file, &masterPage)
+	require.Equal(t, nil, err)
+}
+
+func TestWAL__Disk_Ring__Wait_For_Checkpoint_To_Reuse_Pages(t *testing.T) {
+	w := newWalTest(t, 6, 8) // 4 pages in the ring
+	w.wal.FinishRecover()
+
+	var entries []string
+	var lsnList []LSN
+	for i := 0; i < 6; i++ {
+		// each entry fills a whole page
+		entry := newFullPageEntry(byte('A' + i))
+		entries = append(entries, entry)
+		lsnList = append(lsnList, w.addEntryAndNotify(entry))
+	}
+	assert.Equal(t, LSN(7*testPageSize-1), lsnList[5])
+
+	// only 4 pages can be written
+	assert.Equal(t, nil, w.wal.WaitDurable(lsnList[3]))
+	w.wal.Lock()
+	assert.Equal(t, LSN(5*testPageSize-1), w.wal.GetDurableLSN())
+	w.wal.Unlock()
+
+	// reuse the pages of the first 2 entries
+	w.wal.releaseLogSpace(lsnList[1])
+	assert.Equal(t, nil, w.wal.WaitDurable(lsnList[5]))
+
+	// check pages on disk
+	assert.Equal(t, int64(2*testPageSize), w.wal.diskPageOffset(5))
+	assert.Equal(t, PageNum(5), w.readDiskPage(t, 5).GetPageNum())
+	assert.Equal(t, PageNum(6), w.readDiskPage(t, 6).GetPageNum())
+	assert.Equal(t, PageNum(3), w.readDiskPage(t, 3).GetPageNum())
+	assert.Equal(t, PageNum(4), w.readDiskPage(t, 4).GetPageNum())
+
+	// recover from the checkpoint
+	w.wal.Shutdown()
+	w.writeMasterPage(t, lsnList[1])
+
+	w.openWAL(t)
+	assert.Equal(t, entries[2:], w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+}
+
+func TestWAL__Disk_Ring__Stale_Page_Of_Previous_Lap_Is_End_Of_Log(t *testing.T) {
+	w := newWalTest(t, 6, 8) // 4 pages in the ring
+	w.wal.FinishRecover()
+
+	fullPageEntry := newFullPageEntry('A')
+
+	var lsnList []LSN
+	for i := 0; i < 4; i++ {
+		lsnList = append(lsnList, w.addEntryAndNotify(fullPageEntry))
+	}
+	w.wal.releaseLogSpace(lsnList[2])
+
+	lsn := w.addEntryAndNotify(fullPageEntry) // page 5 overwrites page 1
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+
 	w.wal.Shutdown()
+	w.writeMasterPage(t, lsnList[2])
+
+	// the place of page 6 still contains page 2
+	w.openWAL(t)
+	assert.Equal(t, []string{fullPageEntry, fullPageEntry}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	w.addEntryAndNotify("input06")
+
+	w.reopen(t)
+	assert.Equal(t, []string{fullPageEntry, fullPageEntry, "input06"}, w.readAllRecoverEntries())
+}
+
+func TestWAL__Log_Buffer_Full__Write_Entry_Bigger_Than_Buffer(t *testing.T) {
+	w := newWalTest(t, 20, 2)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	w.addEntryAndNotify("input01")
+
+	bigEntry := strings.Repeat("A", 1000) + strings.Repeat("B", 2000)
+	lsn := w.addEntryAndNotify(bigEntry)
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+
+	w.reopen(t)
+	assert.Equal(t, []string{"input01", bigEntry}, w.readAllRecoverEntries())
+}
+
+func TestWAL__Log_Buffer_Full__Try_Write(t *testing.T) {
+	w := newWalTest(t, 4, 2) // 2 pages in the ring
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	fullPageEntry := newFullPageEntry('A')
+	tryWrite := func() (LSN, error) {
+		w.wal.Lock()
+		defer w.wal.Unlock()
+
+		lsn, err := w.wal.TryWrite(NewSimpleByteReader([]byte(fullPageEntry)))
+		w.wal.NotifyWriter()
+		return lsn, err
+	}
+
+	w.addEntryAndNotify(fullPageEntry)
+	lsn2 := w.addEntryAndNotify(fullPageEntry)
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn2))
+
+	// page 3 & 4 are in memory, waiting for the checkpoint
+	_, err := tryWrite()
+	assert.Equal(t, nil, err)
+	lsn4, err := tryWrite()
+	assert.Equal(t, nil, err)
+
+	_, err = tryWrite()
+	assert.Equal(t, ErrLogBufferFull, err)
+
+	// checkpoint to free pages
+	assert.Equal(t, nil, w.wal.Checkpoint(lsn2))
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn4))
+
+	lsn5, err := tryWrite()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, LSN(6*testPageSize-1), lsn5)
+}
+
+func TestWAL__Try_Write__Entry_Bigger_Than_Log_Buffer(t *testing.T) {
+	w := newWalTest(t, 20, 2)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	w.wal.Lock()
+	defer w.wal.Unlock()
+
+	// 4 pages are needed, the log buffer is empty
+	_, err := w.wal.TryWrite(NewSimpleByteReader([]byte(strings.Repeat("A", 1500))))
+	assert.Equal(t, true, errors.Is(err, ErrEntryTooLarge))
+	assert.Equal(t, "entry too large: entry size 1500 does not fit in the log buffer of 2 pages", err.Error())
+
+	// the entry fills page 1 and page 2
+	lsn, err := w.wal.TryWrite(NewSimpleByteReader([]byte(strings.Repeat("B", 2*testDataSizePerPage-30))))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, PageNum(2), w.wal.layout.ToPageNum(lsn))
+
+	_, err = w.wal.Write(NewSimpleByteReader([]byte(strings.Repeat("A", 1500))))
+	assert.Equal(t, nil, err)
+}
+
+func TestWAL__Log_Buffer_Full__Blocked_Write_Returns_On_Shutdown(t *testing.T) {
+	w := newWalTest(t, 4, 2) // 2 pages in the ring
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	fullPageEntry := newFullPageEntry('A')
+	for i := 0; i < 4; i++ {
+		w.addEntryAndNotify(fullPageEntry)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		w.wal.Lock()
+		defer w.wal.Unlock()
+
+		_, err := w.wal.Write(NewSimpleByteReader([]byte(fullPageEntry)))
+		errCh <- err
+	}()
+
+	w.wal.Shutdown()
+	assert.Equal(t, ErrClosed, <-errCh)
+}
+
+func TestWAL__Write__Exceed_Max_Entry_Size(t *testing.T) {
+	w := newWalTest(t, 20, 4, WithMaxEntrySize(1000))
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	w.wal.Lock()
+	defer w.wal.Unlock()
+
+	_, err := w.wal.Write(NewSimpleByteReader([]byte(strings.Repeat("A", 1001))))
+	assert.Equal(t, true, errors.Is(err, ErrEntryTooLarge))
+	assert.Equal(t, "entry too large: entry size 1001 exceeds the max entry size 1000", err.Error())
+
+	_, err = w.wal.TryWrite(NewSimpleByteReader([]byte(strings.Repeat("A", 1001))))
+	assert.Equal(t, true, errors.Is(err, ErrEntryTooLarge))
+
+	_, err = w.wal.Write(NewSimpleByteReader([]byte(strings.Repeat("A", 1000))))
+	assert.Equal(t, nil, err)
+}
+
+func TestWAL__Min_Log_Buffer__Skip_End_Of_Page(t *testing.T) {
+	w := newWalTest(t, 10, 2)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	// the second entry skips the last 5 bytes of page 1
+	entry1 := strings.Repeat("A", 482)
+	w.addEntryAndNotify(entry1)
+	lsn := w.addEntryAndNotify("B")
+	assert.Equal(t, PageNum(2), w.wal.layout.ToPageNum(lsn))
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+
+	w.reopen(t)
+	assert.Equal(t, []string{entry1, "B"}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+}
+
+func TestWAL__Write__Max_Entry_Size_Of_Log_On_Disk(t *testing.T) {
+	w := newWalTest(t, 10, 2)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	// the default max entry size is reduced to fit in the 8 pages of the log on disk
+	assert.Equal(t, int64(3433), w.wal.options.maxEntrySize)
+
+	// the checkpoint lsn is 5 bytes before the end of page 1 => the next entry starts at page 2
+	lsn := w.addEntryAndNotify(strings.Repeat("A", 482))
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+	assert.Equal(t, nil, w.wal.Checkpoint(lsn))
+
+	w.wal.Lock()
+	_, err := w.wal.Write(NewSimpleByteReader([]byte(strings.Repeat("B", 3434))))
+	w.wal.Unlock()
+	assert.Equal(t, true, errors.Is(err, ErrEntryTooLarge))
+
+	// the entry fills the pages from 2 to 8
+	bigEntry := strings.Repeat("B", 3433)
+	lsn = w.addEntryAndNotify(bigEntry)
+	assert.Equal(t, LSN(9*testPageSize-1), lsn)
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+
+	w.reopen(t)
+	assert.Equal(t, []string{bigEntry}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+}
+
+func TestWAL__Page_Size_4KB(t *testing.T) {
+	w := newWalTest(t, 10, 4, WithPageSize(4096))
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	assert.Equal(t, 4096*10, len(w.readFile(t)))
+
+	bigEntry := strings.Repeat("ABCDEFGHIJ", 1000) // from page 1 to page 3
+	lsn := w.addEntryAndNotify("input01")
+	assert.Equal(t, LSN(4096+pageHeaderSize+13-1), lsn)
+
+	lsn = w.addEntryAndNotify(bigEntry)
+	assert.Equal(t, PageNum(3), w.wal.layout.ToPageNum(lsn))
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+
+	w.reopen(t)
+	assert.Equal(t, []string{"input01", bigEntry}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	masterPage := w.readMasterPage(t)
+	assert.Equal(t, uint8(12), masterPage.PageSizeLog)
+	assert.Equal(t, LSN(4096-1), masterPage.CheckpointLSN)
+}
+
+func TestWAL__Page_Size__Mismatch_On_Reopen(t *testing.T) {
+	w := newWalTest(t, 10, 4, WithPageSize(4096))
+	w.wal.Shutdown()
+
+	_, err := NewWAL(w.fs, w.filename, 4096*10, 4096*4, WithPageSize(8192))
+	assert.Equal(t, true, errors.Is(err, ErrIncompatibleFile))
+	assert.Equal(t,
+		"incompatible wal file: page size 4096 of the WAL file is different from the configured page size 8192",
+		err.Error(),
+	)
+}
+
+func TestWAL__File_Size__Mismatch_On_Reopen(t *testing.T) {
+	w := newWalTest(t, 8, 4)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+	lsn := w.addEntryAndNotify("input01")
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+	w.wal.Shutdown()
+
+	// the default file size
+	_, err := Open(w.fs, w.filename)
+	assert.Equal(t, true, errors.Is(err, ErrIncompatibleFile))
+	assert.Equal(t,
+		"incompatible wal file: file size 4096 of the WAL file is different from the configured file size 67108864",
+		err.Error(),
+	)
+
+	_, err = NewWAL(w.fs, w.filename, testPageSize*10, testPageSize*4)
+	assert.Equal(t, true, errors.Is(err, ErrIncompatibleFile))
+
+	// the lock is released after the error
+	w.openWAL(t)
+	assert.Equal(t, []string{"input01"}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+}
+
+func TestWAL__Page_Size__Invalid(t *testing.T) {
+	fs := filesys.NewMemFileSystem()
+
+	_, err := NewWAL(fs, "/data/wal01", 4096*10, 4096*4, WithPageSize(4000))
+	assert.Equal(t, true, errors.Is(err, ErrInvalidOption))
+	assert.Equal(t, "invalid option: page size 4000 is not a power of two", err.Error())
+
+	_, err = NewWAL(fs, "/data/wal01", 4096*10, 4096*4, WithPageSize(256))
+	assert.Equal(t, true, errors.Is(err, ErrInvalidOption))
+	assert.Equal(t, true, errors.Is(err, ErrInvalidPageSize))
+	assert.Equal(t, "invalid option: invalid page size: page size log 8 is not in range [9, 16]", err.Error())
+}
+
+func TestWAL__Real_File_System__Write_Then_Recover(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "wal01")
+	w := newWalTestOnFS(t, filesys.NewFileSystem(), filename, 10, 4)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	bigEntry := strings.Repeat("ABCDEFGHIJ", 100)
+	w.addEntryAndNotify("input01")
+	w.addEntryAndNotify(bigEntry)
+
+	w.reopen(t)
+	assert.Equal(t, []string{"input01", bigEntry}, w.readAllRecoverEntries())
+	assert.Equal(t, nil, w.wal.FinishRecover())
+
+	info, err := os.Stat(w.filename)
+	require.Equal(t, nil, err)
+	assert.Equal(t, int64(512*10), info.Size())
+}
+
+func TestWAL__Create_File__Fallocate_Error(t *testing.T) {
+	fs := filesys.NewFaultFileSystem(filesys.NewMemFileSystem(), testPageSize, 1)
+
+	fallocateErr := errors.New("fallocate error")
+	fs.InjectError(filesys.FaultOpFallocate, 0, fallocateErr)
+
+	_, err := NewWAL(fs, "/data/wal01", testPageSize*10, testPageSize*4)
+	assert.Equal(t, &IOError{Op: "create", Err: fallocateErr}, err)
+
+	existed, err := fs.Exists("/data/wal01")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, existed)
+}
+
+func TestWAL__Create_File__Rename_Error(t *testing.T) {
+	fs := filesys.NewFaultFileSystem(filesys.NewMemFileSystem(), testPageSize, 1)
+
+	renameErr := errors.New("rename error")
+	fs.InjectError(filesys.FaultOpRename, 0, renameErr)
+
+	_, err := NewWAL(fs, "/data/wal01", testPageSize*10, testPageSize*4)
+	assert.Equal(t, &IOError{Op: "create", Err: renameErr}, err)
+
+	// retry
+	w, err := NewWAL(fs, "/data/wal01", testPageSize*10, testPageSize*4)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, w.FinishRecover())
+	w.Shutdown()
+}
+
+func TestWAL__Create_File__Crash_After_Created(t *testing.T) {
+	modes := []filesys.CrashMode{
+		filesys.CrashDropUnsynced,
+		filesys.CrashReorderUnsynced,
+		filesys.CrashTornWrites,
+	}
+
+	for _, mode := range modes {
+		fs := filesys.NewFaultFileSystem(filesys.NewMemFileSystem(), testPageSize, 1)
+
+		w, err := NewWAL(fs, "/data/wal01", testPageSize*10, testPageSize*4)
+		require.Equal(t, nil, err)
+		w.Shutdown()
+
+		crashed := fs.Crash(mode)
+
+		// all pages are initialized
+		data, err := crashed.Mem().ReadFile("/data/wal01")
+		require.Equal(t, nil, err)
+		assert.Equal(t, testPageSize*10, len(data))
+		assert.Equal(t, make([]byte, testPageSize*9), data[testPageSize:])
+
+		existed, err := crashed.Exists("/data/wal01.tmp")
+		assert.Equal(t, nil, err)
+		assert.Equal(t, false, existed)
+
+		wt := newWalTestOnFS(t, crashed, "/data/wal01", 10, 4)
+		assert.Equal(t, LSN(testPageSize-1), wt.wal.checkpointLsn)
+		assert.Equal(t, []string(nil), wt.readAllRecoverEntries())
+		assert.Equal(t, nil, wt.wal.FinishRecover())
+	}
+}
+
+func TestWAL__Create_File__Crash_On_Error(t *testing.T) {
+	type testCase struct {
+		op   filesys.FaultOp
+		skip int
+	}
+	cases := []testCase{
+		{op: filesys.FaultOpFallocate},
+		{op: filesys.FaultOpWrite, skip: 0}, // master page
+		{op: filesys.FaultOpWrite, skip: 1}, // zero pages
+		{op: filesys.FaultOpSync},
+		{op: filesys.FaultOpRename},
+		{op: filesys.FaultOpSyncDir},
+	}
+
+	modes := []filesys.CrashMode{
+		filesys.CrashDropUnsynced,
+		filesys.CrashReorderUnsynced,
+		filesys.CrashTornWrites,
+	}
+
+	for _, tc := range cases {
+		for _, mode := range modes {
+			fs := filesys.NewFaultFileSystem(filesys.NewMemFileSystem(), testPageSize, 1)
+
+			injectedErr := errors.New("injected error")
+			fs.InjectError(tc.op, tc.skip, injectedErr)
+
+			_, err := NewWAL(fs, "/data/wal01", testPageSize*10, testPageSize*4)
+			require.Equal(t, &IOError{Op: "create", Err: injectedErr}, err)
+
+			// the WAL file is never half initialized after crashing
+			crashed := fs.Crash(mode)
+			existed, err := crashed.Exists("/data/wal01")
+			assert.Equal(t, nil, err)
+			assert.Equal(t, false, existed)
+
+			// create again after restarting
+			w := newWalTestOnFS(t, crashed, "/data/wal01", 10, 4)
+			assert.Equal(t, []string(nil), w.readAllRecoverEntries())
+			assert.Equal(t, nil, w.wal.FinishRecover())
+
+			w.addEntryAndNotify("hello")
+			w.wal.Shutdown()
+
+			w.reopen(t)
+			assert.Equal(t, []string{"hello"}, w.readAllRecoverEntries())
+		}
+	}
+}
+
+func newFailWalTest(t *testing.T, options ...Option) *walTest {
+	fs := filesys.NewFaultFileSystem(filesys.NewMemFileSystem(), testPageSize, 1)
+	w := newWalTestOnFS(t, fs, "/data/wal01", 10, 4, options...)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+	return w
+}
+
+func TestWAL__Fail_Stop__After_Sync_Error(t *testing.T) {
+	w := newFailWalTest(t)
+
+	lsn1 := w.addEntryAndNotify("input01")
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn1))
+	assert.Equal(t, nil, w.wal.Err())
+
+	syncErr := errors.New("sync error")
+	w.fs.(*filesys.FaultFileSystem).InjectError(filesys.FaultOpSync, 0, syncErr)
+
+	lsn2 := w.addEntryAndNotify("input02")
+	expectedErr := &IOError{Op: "sync", Err: syncErr}
+	assert.Equal(t, expectedErr, w.wal.WaitDurable(lsn2))
+	assert.Equal(t, expectedErr, w.wal.Err())
+
+	// the already durable lsn is not affected
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn1))
+
+	// future calls return the same error
+	w.wal.Lock()
+	_, err := w.wal.Write(NewSimpleByteReader([]byte("input03")))
+	w.wal.Unlock()
+	assert.Equal(t, expectedErr, err)
+
+	_, err = w.wal.NewEntry(10)
+	assert.Equal(t, expectedErr, err)
+
+	assert.Equal(t, expectedErr, w.wal.WaitDurable(lsn2+100))
+	assert.Equal(t, expectedErr, w.wal.Checkpoint(lsn1))
+
+	// the background writer is stopped, the sync error is not retried
+	w.wal.Lock()
+	w.wal.NotifyWriter()
+	w.wal.Unlock()
+	w.wal.Shutdown()
+	assert.Equal(t, expectedErr, w.wal.Err())
+}
+
+func TestWAL__Fail_Stop__Release_Blocked_Writer(t *testing.T) {
+	w := newFailWalTest(t)
+
+	writeErr := errors.New("write error")
+	w.fs.(*filesys.FaultFileSystem).InjectError(filesys.FaultOpWrite, 0, writeErr)
+
+	// the entry is bigger than the log buffer => the writer is blocked until flushing
+	w.wal.Lock()
+	_, err := w.wal.Write(NewSimpleByteReader([]byte(strings.Repeat("A", 6*testPageSize))))
+	w.wal.Unlock()
+	assert.Equal(t, &IOError{Op: "write", Err: writeErr}, err)
+	assert.Equal(t, &IOError{Op: "write", Err: writeErr}, w.wal.Err())
+}
+
+func TestWAL__Fail_Stop__Callback(t *testing.T) {
+	callbackErrs := make(chan error, 2)
+	w := newFailWalTest(t, WithFailCallback(func(err error) {
+		callbackErrs <- err
+	}))
+
+	syncErr := errors.New("sync error")
+	w.fs.(*filesys.FaultFileSystem).InjectError(filesys.FaultOpSync, 0, syncErr)
+
+	lsn := w.addEntryAndNotify("input01")
+	assert.Equal(t, &IOError{Op: "sync", Err: syncErr}, w.wal.WaitDurable(lsn))
+	assert.Equal(t, &IOError{Op: "sync", Err: syncErr}, <-callbackErrs)
+
+	// called only once
+	assert.Equal(t, &IOError{Op: "sync", Err: syncErr}, w.wal.Checkpoint(lsn))
+	w.wal.Shutdown()
+	assert.Equal(t, 0, len(callbackErrs))
+}
+
+func TestWAL__Fail_Stop__Callback_Calls_Shutdown(t *testing.T) {
+	t.Run("writer error", func(t *testing.T) {
+		shutdownDone := make(chan error, 1)
+		var w *walTest
+		w = newFailWalTest(t, WithFailCallback(func(err error) {
+			w.wal.Shutdown()
+			shutdownDone <- err
+		}))
+
+		syncErr := errors.New("sync error")
+		w.fs.(*filesys.FaultFileSystem).InjectError(filesys.FaultOpSync, 0, syncErr)
+		lsn := w.addEntryAndNotify("input01")
+
+		select {
+		case err := <-shutdownDone:
+			assert.Equal(t, &IOError{Op: "sync", Err: syncErr}, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("shutdown in the fail callback is blocked")
+		}
+		assert.Equal(t, &IOError{Op: "sync", Err: syncErr}, w.wal.WaitDurable(lsn))
+	})
+
+	t.Run("checkpoint error", func(t *testing.T) {
+		shutdownDone := make(chan error, 1)
+		var w *walTest
+		w = newFailWalTest(t, WithFailCallback(func(err error) {
+			_ = w.wal.Checkpoint(0)
+			w.wal.Shutdown()
+			shutdownDone <- err
+		}))
+
+		lsn := w.addEntryAndNotify("input01")
+		assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+
+		syncErr := errors.New("sync error")
+		w.fs.(*filesys.FaultFileSystem).InjectError(filesys.FaultOpSync, 0, syncErr)
+		assert.Equal(t, &IOError{Op: "sync", Err: syncErr}, w.wal.Checkpoint(lsn))
+
+		select {
+		case err := <-shutdownDone:
+			assert.Equal(t, &IOError{Op: "sync", Err: syncErr}, err)
+		default:
+			t.Fatal("the fail callback is not called by Checkpoint")
+		}
+	})
+}
+
+func TestWAL__Fail_Stop__Panic(t *testing.T) {
+	w := newFailWalTest(t, WithFailPolicy(FailPolicyPanic))
+
+	lsn := w.addEntryAndNotify("input01")
+	assert.Equal(t, nil, w.wal.WaitDurable(lsn))
+
+	// the checkpoint panics in the caller goroutine
+	syncErr := errors.New("sync error")
+	w.fs.(*filesys.FaultFileSystem).InjectError(filesys.FaultOpSync, 0, syncErr)
+	panicValue := func() (value any) {
+		defer func() { value = recover() }()
+		_ = w.wal.Checkpoint(lsn)
+		return nil
+	}()
+	assert.Equal(t, &IOError{Op: "sync", Err: syncErr}, panicValue)
+
+	assert.Equal(t, &IOError{Op: "sync", Err: syncErr}, w.wal.Err())
+	w.wal.Lock()
+	_, err := w.wal.Write(NewSimpleByteReader([]byte("input02")))
+	w.wal.Unlock()
+	assert.Equal(t, &IOError{Op: "sync", Err: syncErr}, err)
+}
+
+func TestWAL__File_Lock__Already_Opened(t *testing.T) {
+	w := newWalTest(t, 10, 4)
+
+	_, err := NewWAL(w.fs, w.filename, testPageSize*10, testPageSize*4)
+	assert.Equal(t, true, errors.Is(err, ErrFileLocked))
+	assert.Equal(t, "file is locked by another process: wal file /data/wal01 is already opened", err.Error())
+
+	// the lock is released by Shutdown
+	w.reopen(t)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+}
+
+func TestWAL__File_Lock__Released_On_Open_Error(t *testing.T) {
+	w := newWalTest(t, 10, 4)
+	w.wal.Shutdown()
+
+	_, err := NewWAL(w.fs, w.filename, testPageSize*10, testPageSize*4, WithChecksum(ChecksumCRC32C))
+	assert.Equal(t, false, errors.Is(err, ErrFileLocked))
+
+	w.openWAL(t)
+	assert.Equal(t, nil, w.wal.FinishRecover())
+}
+
+func TestWAL__File_Lock__Real_File_System(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "wal01")
+	w := newWalTestOnFS(t, filesys.NewFileSystem(), filename, 10, 4)
+
+	_, err := NewWAL(w.fs, filename, testPageSize*10, testPageSize*4)
+	assert.Equal(t, true, errors.Is(err, ErrFileLocked))
+
+	w.reopen(t)
+	assert.Equal(t, nil, w.wal.FinishRecover())
 }
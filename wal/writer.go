@@ -1,29 +1,269 @@
 package wal
 
+import (
+	"time"
+
+	"github.com/QuangTung97/go-wal/wal/types"
+)
+
 func (w *WAL) runWriterInBackground() {
-	defer w.wg.Done()
+	failErr := w.runWriterLoop()
+
+	w.releaseAllWaiters()
+	w.wg.Done()
+
+	// the writer is already stopped => the fail callback can call Shutdown
+	if failErr != nil {
+		w.applyFailPolicy(failErr)
+	}
+}
+
+// runWriterLoop flushes the written pages until the WAL is closed or failed.
+// Returns the error if the WAL is failed by this writer
+func (w *WAL) runWriterLoop() error {
 	for {
-		closed := w.runWriterInBackgroundPerIteration()
-		if closed {
-			return
+		stopped, failErr := w.runWriterInBackgroundPerIteration()
+		if stopped {
+			return failErr
 		}
 	}
 }
 
-func (w *WAL) runWriterInBackgroundPerIteration() bool {
+// flushRange is the range of pages that will be written to disk in one iteration
+type flushRange struct {
+	fromPage PageNum
+	toPage   PageNum
+	toLsn    LSN
+
+	// the first page already contains durable bytes, so it is rewritten in place
+	rewrite bool
+}
+
+func (r flushRange) numPages() PageNum {
+	return r.toPage - r.fromPage + 1
+}
+
+func (w *WAL) runWriterInBackgroundPerIteration() (bool, error) {
+	if w.options.flushDelay > 0 {
+		w.waitForFlushDelay()
+	}
+
+	flushed, ok := w.waitAndCopyPages()
+	if !ok {
+		return true, nil
+	}
+
+	start := time.Now()
+	if err := w.writePagesToDisk(flushed); err != nil {
+		if w.fail(err) {
+			return true, err
+		}
+		return true, nil
+	}
+
+	w.mut.Lock()
+	w.durableLsn = flushed.toLsn
+	w.cond.Broadcast()
+	w.mut.Unlock()
+
+	w.durableWaiter.SetLSN(types.LSN(flushed.toLsn))
+
+	if fn := w.options.metrics.OnFlush; fn != nil {
+		fn(int64(flushed.numPages()), time.Since(start))
+	}
+
+	return false, nil
+}
+
+// waitForFlushDelay waits until there are new written bytes,
+// then sleeps for the flush delay to let more entries be written before flushing
+func (w *WAL) waitForFlushDelay() {
+	w.mut.Lock()
+	for w.needWaitToFlush() {
+		w.cond.Wait()
+	}
+	stopped := w.isClosed || w.writeErr != nil
+	w.mut.Unlock()
+
+	if !stopped {
+		time.Sleep(w.options.flushDelay)
+	}
+}
+
+// needWaitToFlush returns true if there are no new bytes to flush and the writer is not stopped.
+// Needs to be called inside mutex lock
+func (w *WAL) needWaitToFlush() bool {
+	if w.flushableLsn() > w.durableLsn {
+		return false
+	}
+	if w.isClosed {
+		return false
+	}
+	if w.writeErr != nil {
+		return false
+	}
+	return true
+}
+
+// waitAndCopyPages waits until there are new written bytes or the WAL is closed.
+// Then it copies the not yet durable pages to the flush buffer.
+// Returns false if the WAL is closed and all written bytes are durable
+func (w *WAL) waitAndCopyPages() (flushRange, bool) {
 	w.mut.Lock()
 	defer w.mut.Unlock()
 
-	needWait := func() bool {
-		if w.isClosed {
-			return false
+	for w.needWaitToFlush() {
+		w.cond.Wait()
+	}
+
+	if w.writeErr != nil {
+		return flushRange{}, false
+	}
+
+	if w.flushableLsn() <= w.durableLsn {
+		return flushRange{}, false
+	}
+
+	toLsn := w.flushableLsn()
+	r := flushRange{
+		fromPage: w.layout.ToPageNum(w.durableLsn + 1),
+		toPage:   w.layout.ToPageNum(toLsn),
+		toLsn:    toLsn,
+		rewrite:  w.layout.WithinPage(w.durableLsn+1) > pageHeaderSize,
+	}
+
+	lastIndex := r.numPages() - 1
+	for i := PageNum(0); i < lastIndex; i++ {
+		page := w.getFlushPage(i)
+		copy(page.data, w.getInMemPage(r.fromPage+i).data)
+	}
+
+	// bytes after toLsn in the last page can be concurrently written by other writers
+	// => only copy until toLsn and clear the remaining bytes
+	within := w.layout.WithinPage(r.toLsn) + 1
+	lastPage := w.getFlushPage(lastIndex)
+	copy(lastPage.data, w.getInMemPage(r.fromPage + lastIndex).data[:within])
+	clear(lastPage.data[within:])
+	if within < w.layout.PageSize() {
+		lastPage.GetFlags().SetNotFull(true)
+	}
+
+	return r, true
+}
+
+func (w *WAL) getFlushPage(index PageNum) Page {
+	pageSize := w.layout.PageSize()
+	return Page{
+		data:     w.flushBuffer[int64(index)*pageSize : int64(index+1)*pageSize],
+		crcTable: w.checksumTable,
+	}
+}
+
+// flushableLsn returns the highest lsn that can be written to disk
+// without overwriting the pages after the checkpoint lsn.
+// Needs to be called inside mutex lock
+func (w *WAL) flushableLsn() LSN {
+	endPage := w.layout.ToPageNum(w.checkpointLsn+1) + w.diskRingNumPage()
+	return min(w.writtenLsn, w.layout.PageStart(endPage)-1)
+}
+
+// fail puts the WAL into the failed state after a write or fsync error.
+// The state of the data in the page cache is unknown after a failed fsync,
+// so the WAL never retries: the background writer stops and all pending
+// and future Write and WaitDurable calls return err.
+// Returns true if err is the first error, then the caller must apply the fail policy
+func (w *WAL) fail(err error) bool {
+	w.mut.Lock()
+	firstErr := w.writeErr == nil
+	if firstErr {
+		w.writeErr = err
+	}
+	w.cond.Broadcast()
+	w.mut.Unlock()
+
+	w.releaseAllWaiters()
+
+	if firstErr {
+		w.options.logger.Error("wal is failed after a write or fsync error", "error", err)
+	}
+	return firstErr
+}
+
+// applyFailPolicy panics or calls the fail callback with the first error of the WAL.
+// Must NOT be called inside mutex lock or by the background writer before it is stopped
+func (w *WAL) applyFailPolicy(err error) {
+	switch w.options.failPolicy {
+	case FailPolicyPanic:
+		panic(err)
+	case FailPolicyCallback:
+		if w.options.failCallback != nil {
+			w.options.failCallback(err)
 		}
-		return true
+	default:
 	}
+}
 
-	for needWait() {
-		w.cond.Wait()
+func (w *WAL) writePagesToDisk(r flushRange) error {
+	for i := PageNum(0); i < r.numPages(); i++ {
+		page := w.getFlushPage(i)
+		page.writeChecksum()
+	}
+
+	if r.rewrite && w.tailBackupNumPage() > 0 {
+		firstPage := w.getFlushPage(0)
+		if err := w.writeTailBackup(firstPage.data); err != nil {
+			return err
+		}
+	}
+
+	// write the contiguous runs of pages on disk
+	start := PageNum(0)
+	for start < r.numPages() {
+		end := start + 1
+		for end < r.numPages() && w.diskPageIndex(r.fromPage+end) != 0 {
+			end++
+		}
+
+		pageSize := w.layout.PageSize()
+		data := w.flushBuffer[int64(start)*pageSize : int64(end)*pageSize]
+		offset := w.diskPageOffset(r.fromPage + start)
+		if _, err := w.file.WriteAt(data, offset); err != nil {
+			return newIOError("write", err)
+		}
+		start = end
 	}
 
-	return w.isClosed
+	return newIOError("sync", w.syncFile())
+}
+
+// syncFile makes the written data of the WAL file durable, using the configured sync mode
+func (w *WAL) syncFile() error {
+	if w.options.syncMode == SyncModeFsync {
+		return w.file.Sync()
+	}
+	return w.file.Datasync()
+}
+
+// atomicWriteSize is the size of the writes that are assumed to be never torn by a crash,
+// the sector size of most disks
+const atomicWriteSize = 1 << MinPageSizeLog
+
+// tailBackupNumPageOf returns the number of tail backup pages of the page size.
+// A page bigger than atomicWriteSize can be torn when rewritten in place,
+// losing the durable bytes at the start of the page.
+// So the new version of the page is durably written to the tail backup page before rewriting it,
+// the recovery reads the tail backup page if the page is torn
+func tailBackupNumPageOf(pageSize int64) PageNum {
+	if pageSize > atomicWriteSize {
+		return 1
+	}
+	return 0
+}
+
+// writeTailBackup durably writes the data of a page with its checksum to the tail backup page
+func (w *WAL) writeTailBackup(data []byte) error {
+	if _, err := w.file.WriteAt(data, w.tailBackupOffset()); err != nil {
+		return newIOError("write", err)
+	}
+	return newIOError("sync", w.syncFile())
 }